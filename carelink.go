@@ -1,7 +1,25 @@
 package gorileylink
 
+import (
+	"encoding/hex"
+	"fmt"
+)
+
 // Carelink describes the Medtronic exchange protocol
 
+// MMTPacketType is the first byte of every Medtronic RF packet and
+// tells what kind of device is talking
+type MMTPacketType byte
+
+const (
+	MMTPacketMySentry MMTPacketType = 0xa2
+	MMTPacketMeter    MMTPacketType = 0xa5
+	MMTPacketRemote   MMTPacketType = 0xa6 // MMT-503
+	MMTPacketCarelink MMTPacketType = 0xa7
+	MMTPacketSensor   MMTPacketType = 0xaa // MiniLink
+	MMTPacketSensor2  MMTPacketType = 0xab // Enlite
+)
+
 // CarelinkMessageType is the literal type of commands
 type CarelinkMessageType byte
 
@@ -37,6 +55,7 @@ const (
 	CMTSelectBasalProfile CarelinkMessageType = 0x4a
 
 	CMTChangeTempBasal CarelinkMessageType = 0x4c
+	CMTSuspendResume   CarelinkMessageType = 0x4d // CMD_SET_SUSPEND
 
 	CMTPumpExperimentOP80      CarelinkMessageType = 0x50
 	CMTSetRemoteControlID      CarelinkMessageType = 0x51 // CMD_SET_RF_REMOTE_ID
@@ -63,6 +82,8 @@ const (
 	CMTSetBolusWizardEnabled5 CarelinkMessageType = 0x65
 	CMTSetAlarmClockEnable    CarelinkMessageType = 0x67
 
+	CMTChangeTempBasalPercent CarelinkMessageType = 0x69 // CMD_SET_TEMP_BASAL_PERCENT
+
 	CMTSetMaxBasalRate         CarelinkMessageType = 0x6e // CMD_SET_MAX_BASAL
 	CMTSetBasalProfileStandard CarelinkMessageType = 0x6f // CMD_SET_STD_PROFILE
 
@@ -72,8 +93,14 @@ const (
 	CMTReadFirmwareVersion  CarelinkMessageType = 0x74
 	CMTReadErrorStatus      CarelinkMessageType = 0x75
 	CMTReadRemoteControlIDs CarelinkMessageType = 0x76 // CMD_READ_REMOTE_CTRL_IDS
+	CMTReadTodaysTotals     CarelinkMessageType = 0x79
 
 	CMTGetHistoryPage         CarelinkMessageType = 0x80
+	CMTReadCarbUnits          CarelinkMessageType = 0x88
+	CMTReadBGUnits            CarelinkMessageType = 0x89
+	CMTReadCarbRatios         CarelinkMessageType = 0x8a
+	CMTReadSensitivities      CarelinkMessageType = 0x8b
+	CMTReadBGTargets          CarelinkMessageType = 0x8c
 	CMTGetPumpModel           CarelinkMessageType = 0x8d
 	CMTReadProfileSTD512      CarelinkMessageType = 0x92
 	CMTReadProfileA512        CarelinkMessageType = 0x93
@@ -81,6 +108,7 @@ const (
 	CMTReadTempBasal          CarelinkMessageType = 0x98
 	CMTGetGlucosePage         CarelinkMessageType = 0x9A
 	CMTReadCurrentPageNumber  CarelinkMessageType = 0x9d
+	CMTReadBGTargets515       CarelinkMessageType = 0x9f
 	CMTReadSettings           CarelinkMessageType = 0xc0
 	CMTReadCurrentGlucosePage CarelinkMessageType = 0xcd
 	CMTReadPumpStatus         CarelinkMessageType = 0xce
@@ -94,3 +122,79 @@ const (
 	CMTChangeCaptureEventEnable CarelinkMessageType = 0xf2
 	CMTReadOtherDevicesStatus   CarelinkMessageType = 0xf3
 )
+
+const (
+	// carelinkBodyLength is the size of a full (non-short) message body
+	carelinkBodyLength = 65
+)
+
+// ParsePumpID converts a pump serial (e.g. "123456") into its 3-byte
+// on-air form
+func ParsePumpID(pumpID string) ([]byte, error) {
+	id, err := hex.DecodeString(pumpID)
+	if err != nil {
+		return nil, err
+	} else if len(id) != 3 {
+		return nil, fmt.Errorf("pump ID must be 6 digits: %v", pumpID)
+	}
+	return id, nil
+}
+
+// NewCarelinkShortMessage creates a parameterless command
+func NewCarelinkShortMessage(cmt CarelinkMessageType) *CarelinkMessage {
+	return &CarelinkMessage{cmt, []byte{0x00}}
+}
+
+// NewCarelinkParamMessage creates a command carrying parameters; the body
+// is the parameter count followed by the parameters, zero-padded
+func NewCarelinkParamMessage(cmt CarelinkMessageType, params []byte) *CarelinkMessage {
+	body := make([]byte, carelinkBodyLength)
+	body[0] = byte(len(params))
+	copy(body[1:], params)
+	return &CarelinkMessage{cmt, body}
+}
+
+// Packet frames the message for the given pump, with its trailing CRC8
+func (cm *CarelinkMessage) Packet(pumpID []byte) []byte {
+	return cm.PacketAs(MMTPacketCarelink, pumpID)
+}
+
+// PacketAs frames the message as another kind of packet, for the devices
+// that share the Carelink message layout
+func (cm *CarelinkMessage) PacketAs(mpt MMTPacketType, deviceID []byte) []byte {
+	packet := make([]byte, 0, 6+len(cm.Data))
+	packet = append(packet, byte(mpt))
+	packet = append(packet, deviceID...)
+	packet = append(packet, byte(cm.MessageType))
+	packet = append(packet, cm.Data...)
+	return append(packet, CRC8(packet))
+}
+
+// ParseMMTPacket unframes a decoded packet of any kind sharing the
+// Carelink message layout, returning its kind, the device ID it came
+// from and the message it carries
+func ParseMMTPacket(packet []byte) (MMTPacketType, []byte, *CarelinkMessage, error) {
+	if len(packet) < 6 {
+		return 0, nil, nil, fmt.Errorf("short packet: %x", packet)
+	}
+	crc := packet[len(packet)-1]
+	packet = packet[:len(packet)-1]
+	if CRC8(packet) != crc {
+		return 0, nil, nil, fmt.Errorf("bad CRC8: %x", packet)
+	}
+	data := make([]byte, len(packet)-5)
+	copy(data, packet[5:])
+	return MMTPacketType(packet[0]), packet[1:4], &CarelinkMessage{CarelinkMessageType(packet[4]), data}, nil
+}
+
+// ParseCarelinkPacket unframes a decoded packet from a pump, returning the
+// pump ID it came from and the message it carries
+func ParseCarelinkPacket(packet []byte) ([]byte, *CarelinkMessage, error) {
+	mpt, pumpID, msg, err := ParseMMTPacket(packet)
+	if err != nil {
+		return nil, nil, err
+	} else if mpt != MMTPacketCarelink {
+		return nil, nil, fmt.Errorf("not a Carelink packet: %x", packet)
+	}
+	return pumpID, msg, nil
+}
@@ -0,0 +1,138 @@
+// grl-sensor: log sensor transmitter (MiniLink/Enlite) packets as JSON
+// e.g. ./grl-sensor aa:bb:cc:dd:ee:ff
+// e.g. ./grl-sensor -transmitter 123456 DaveyLink
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/currantlabs/ble"
+	"github.com/currantlabs/ble/linux"
+	"github.com/thecubic/gorileylink"
+	"golang.org/x/net/context"
+)
+
+var (
+	timeout       = flag.Duration("timeout", 10*time.Second, "connection timeout")
+	listen        = flag.Duration("listen", 5*time.Minute, "how long to listen for")
+	poll          = flag.Duration("poll", 10*time.Second, "how long each receive waits")
+	transmitter   = flag.String("transmitter", "", "only log this transmitter ID")
+	maxFailures   = flag.Int("failures", 5, "give up after this many receive errors in a row")
+	debug         = flag.Bool("debug", false, "enable debugging messages")
+	wg            sync.WaitGroup
+	hci           *linux.Device
+	ctx           context.Context
+	blec          ble.Client
+	nameoraddress string
+	err           error
+	rileylink     *gorileylink.ConnectedRileyLink
+)
+
+func main() {
+	flag.Parse()
+
+	log.SetFormatter(&log.JSONFormatter{})
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+
+	nameoraddress = flag.Arg(0)
+	if nameoraddress == "" {
+		fmt.Println("usage: grl-sensor [-transmitter id] <address-or-name>")
+		os.Exit(1)
+	}
+
+	// boilerplate connect to rileylink
+	hci, ctx = gorileylink.OpenBLE(*timeout)
+	blec, err = gorileylink.ConnectNameOrAddress(ctx, nameoraddress)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"rileylink": nameoraddress,
+			"err":       err,
+		}).Fatal("connection failed")
+	} else {
+		log.WithFields(log.Fields{
+			"rileylink": nameoraddress,
+		}).Debug("connection succeeded")
+	}
+
+	rileylink, err = gorileylink.AttachBTLE(blec)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"rileylink": nameoraddress,
+			"err":       err,
+		}).Fatal("couldn't bind connected device as RileyLink")
+	} else {
+		log.WithFields(log.Fields{
+			"rileylink": nameoraddress,
+		}).Debug("bind as RileyLink succeeded")
+	}
+
+	// launch a goroutine to wrap BLE disconnection for a clean exit
+	go func() {
+		defer wg.Done()
+		<-blec.Disconnected()
+	}()
+	wg.Add(1)
+	// this will delay program exit until cleanly disconnected.
+	// since this is probably Bluetooth-API-over-IPC, not doing
+	// this may persist undesired HCI state
+	defer wg.Wait()
+	// end boilerplate connect to rileylink
+
+	err = rileylink.NotifySubscribe()
+	if err != nil {
+		log.WithField("err", err).Fatal("BLE Subscription Failed")
+	}
+
+	deadline := time.Now().Add(*listen)
+	failures := 0
+	for time.Now().Before(deadline) {
+		packet, err := gorileylink.GetSensorPacket(rileylink, *poll)
+		if err != nil {
+			failures++
+			log.WithFields(log.Fields{
+				"failures": failures,
+				"err":      err,
+			}).Warn("GetSensorPacket")
+			if failures >= *maxFailures {
+				log.WithField("failures", failures).Error("giving up")
+				break
+			}
+			// back off, doubling each time, before trying again
+			time.Sleep(time.Second << uint(failures-1))
+			continue
+		}
+		failures = 0
+		if packet == nil {
+			continue
+		} else if *transmitter != "" && packet.TransmitterID != strings.ToLower(*transmitter) {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"type":        packet.Type,
+			"transmitter": packet.TransmitterID,
+			"version":     packet.Version,
+			"adjustment":  packet.Adjustment,
+			"sequence":    packet.Sequence,
+			"isig":        packet.ISIG,
+			"isigNA":      gorileylink.ISIGNanoamps(packet.ISIG),
+			"backfill":    packet.Backfill,
+			"battery":     packet.Battery,
+			"rssi":        packet.RSSI,
+		}).Info("Sensor Packet")
+	}
+
+	// disconnect from rileylink
+	blec.CancelConnection()
+}
@@ -0,0 +1,81 @@
+// crc.go contains the checksums used by Medtronic RF packets and pages,
+// and the lookup tables they and the Omnipod checksums are built on
+
+package gorileylink
+
+// CRC8Table is an MSB-first CRC-8 lookup table for one polynomial
+type CRC8Table [256]byte
+
+// NewCRC8Table builds the lookup table for a CRC-8 polynomial
+func NewCRC8Table(polynomial byte) *CRC8Table {
+	var table CRC8Table
+	for i := range table {
+		crc := byte(i)
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return &table
+}
+
+// Checksum computes the CRC-8 of data, starting from zero
+func (table *CRC8Table) Checksum(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc = table[crc^b]
+	}
+	return crc
+}
+
+// CRC16Table is an MSB-first CRC-16 lookup table for one polynomial.  How
+// the register is shifted through it is up to each checksum
+type CRC16Table [256]uint16
+
+// NewCRC16Table builds the lookup table for a CRC-16 polynomial
+func NewCRC16Table(polynomial uint16) *CRC16Table {
+	var table CRC16Table
+	for i := range table {
+		crc := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return &table
+}
+
+const (
+	// crc8Polynomial is the CRC-8 polynomial trailing every RF packet
+	crc8Polynomial = 0x9b
+	// crc16Polynomial is the CRC-16 (CCITT) polynomial trailing history
+	// and glucose pages
+	crc16Polynomial = 0x1021
+)
+
+var (
+	crc8Table  = NewCRC8Table(crc8Polynomial)
+	crc16Table = NewCRC16Table(crc16Polynomial)
+)
+
+// CRC8 computes the Medtronic RF packet checksum
+func CRC8(data []byte) byte {
+	return crc8Table.Checksum(data)
+}
+
+// CRC16 computes the Medtronic page checksum
+func CRC16(data []byte) uint16 {
+	var crc uint16 = 0xffff
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
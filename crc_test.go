@@ -0,0 +1,29 @@
+// crc_test.go contains tests of the checksums against their standard
+// check values
+
+package gorileylink
+
+import (
+	"testing"
+)
+
+// the standard input CRC check values are given for
+var crcCheckInput = []byte("123456789")
+
+func TestCRC8(t *testing.T) {
+	// CRC-8/LTE
+	if crc := CRC8(crcCheckInput); crc != 0xea {
+		t.Errorf("CRC8 %02x", crc)
+	}
+	// CRC-8/SMBUS, as used by Omnipod packets
+	if crc := NewCRC8Table(0x07).Checksum(crcCheckInput); crc != 0xf4 {
+		t.Errorf("CRC8 with polynomial 0x07 %02x", crc)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// CRC-16/CCITT-FALSE
+	if crc := CRC16(crcCheckInput); crc != 0x29b1 {
+		t.Errorf("CRC16 %04x", crc)
+	}
+}
@@ -0,0 +1,77 @@
+// fourbsixb.go contains the 4b6b line code Medtronic devices use on air
+
+package gorileylink
+
+// each nibble is sent as one of these 6-bit symbols
+var fourbsixbCodes = [16]byte{
+	0x15, 0x31, 0x32, 0x23, 0x34, 0x25, 0x26, 0x16,
+	0x1a, 0x19, 0x2a, 0x0b, 0x2c, 0x0d, 0x0e, 0x1c,
+}
+
+var fourbsixbNibbles = makeFourbsixbNibbles()
+
+func makeFourbsixbNibbles() [64]int {
+	var nibbles [64]int
+	for i := range nibbles {
+		nibbles[i] = -1
+	}
+	for nibble, code := range fourbsixbCodes {
+		nibbles[code] = nibble
+	}
+	return nibbles
+}
+
+// Encode4b6b encodes bytes as 6-bit symbols, zero-padding the final byte
+func Encode4b6b(data []byte) []byte {
+	var (
+		acc  uint32
+		bits uint
+	)
+	encoded := make([]byte, 0, (len(data)*3+1)/2)
+	for _, b := range data {
+		acc = acc<<12 | uint32(fourbsixbCodes[b>>4])<<6 | uint32(fourbsixbCodes[b&0x0f])
+		bits += 12
+		for bits >= 8 {
+			bits -= 8
+			encoded = append(encoded, byte(acc>>bits))
+		}
+		acc &= 1<<bits - 1
+	}
+	if bits > 0 {
+		encoded = append(encoded, byte(acc<<(8-bits)))
+	}
+	return encoded
+}
+
+// Decode4b6b decodes 6-bit symbols back into bytes.  Decoding stops at
+// the first symbol that isn't valid, which is how the end of a packet
+// (the zero terminator or trailing noise) is found, so a packet cut
+// short by a bad symbol is left for the CRC to catch
+func Decode4b6b(encoded []byte) []byte {
+	var (
+		acc    uint32
+		bits   uint
+		high   int
+		inbyte bool
+	)
+	decoded := make([]byte, 0, len(encoded)*2/3)
+	for _, b := range encoded {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 6 {
+			bits -= 6
+			nibble := fourbsixbNibbles[(acc>>bits)&0x3f]
+			if nibble < 0 {
+				return decoded
+			}
+			if inbyte {
+				decoded = append(decoded, byte(high<<4|nibble))
+			} else {
+				high = nibble
+			}
+			inbyte = !inbyte
+		}
+		acc &= 1<<bits - 1
+	}
+	return decoded
+}
@@ -0,0 +1,44 @@
+// manchester.go contains the Manchester line code Omnipod devices use
+// on air
+
+package gorileylink
+
+// EncodeManchester sends each bit as two: 1 as 10 and 0 as 01, most
+// significant bit first
+func EncodeManchester(data []byte) []byte {
+	encoded := make([]byte, 0, len(data)*2)
+	for _, b := range data {
+		var symbols uint16
+		for i := 7; i >= 0; i-- {
+			if b>>uint(i)&0x01 == 1 {
+				symbols = symbols<<2 | 0x02
+			} else {
+				symbols = symbols<<2 | 0x01
+			}
+		}
+		encoded = append(encoded, byte(symbols>>8), byte(symbols))
+	}
+	return encoded
+}
+
+// DecodeManchester decodes bit pairs back into bytes.  Like Decode4b6b,
+// decoding stops at the first pair that isn't a valid symbol
+func DecodeManchester(encoded []byte) []byte {
+	decoded := make([]byte, 0, len(encoded)/2)
+	for i := 0; i+1 < len(encoded); i += 2 {
+		symbols := uint16(encoded[i])<<8 | uint16(encoded[i+1])
+		var b byte
+		for j := 7; j >= 0; j-- {
+			switch symbols >> uint(j*2) & 0x03 {
+			case 0x02:
+				b = b<<1 | 0x01
+			case 0x01:
+				b = b << 1
+			default:
+				return decoded
+			}
+		}
+		decoded = append(decoded, b)
+	}
+	return decoded
+}
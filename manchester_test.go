@@ -0,0 +1,23 @@
+// manchester_test.go contains tests of the Manchester line code
+
+package gorileylink
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestManchester(t *testing.T) {
+	data := []byte{0x00, 0xff, 0xa5}
+	encoded := EncodeManchester(data)
+	if want := []byte{0x55, 0x55, 0xaa, 0xaa, 0x99, 0x66}; !bytes.Equal(encoded, want) {
+		t.Errorf("encoded as %x", encoded)
+	}
+	if decoded := DecodeManchester(encoded); !bytes.Equal(decoded, data) {
+		t.Errorf("decoded as %x", decoded)
+	}
+	// decoding stops at the first invalid pair
+	if decoded := DecodeManchester(append(encoded, 0x00, 0x00, 0x55, 0x55)); !bytes.Equal(decoded, data) {
+		t.Errorf("decoded past an invalid pair as %x", decoded)
+	}
+}
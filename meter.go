@@ -0,0 +1,122 @@
+// meter.go contains listening to linked glucose meters (e.g. the Contour
+// Next Link) sending fingersticks to their pump
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// meter packets are type, meter ID, flags and glucose, CRC8
+	meterPacketLength = 7
+	// a meter sends each reading several times over
+	meterRepeatWindow = time.Minute
+)
+
+// MeterReading is a fingerstick broadcast by a linked meter
+type MeterReading struct {
+	MeterID string
+	// Glucose is in mg/dL
+	Glucose int
+	// Ack is set on the meter's acknowledgement packets
+	Ack      bool
+	Received time.Time
+	RSSI     int
+}
+
+// DecodeMeterPacket unpacks a decoded 0xa5 packet
+func DecodeMeterPacket(packet []byte) (*MeterReading, error) {
+	if len(packet) != meterPacketLength {
+		return nil, fmt.Errorf("meter packet is %d bytes, expected %d: %x", len(packet), meterPacketLength, packet)
+	} else if MMTPacketType(packet[0]) != MMTPacketMeter {
+		return nil, fmt.Errorf("not a meter packet: %x", packet)
+	} else if CRC8(packet[:6]) != packet[6] {
+		return nil, fmt.Errorf("bad CRC8: %x", packet)
+	}
+	return &MeterReading{
+		MeterID: hex.EncodeToString(packet[1:4]),
+		Glucose: int(packet[4]&0x01)<<8 | int(packet[5]),
+		Ack:     packet[4]>>1&0x03 == 0x03,
+	}, nil
+}
+
+// MeterListener sits on the meter channel decoding what linked meters send
+type MeterListener struct {
+	rileylink PacketRadio
+	meterIDs  map[string]bool
+	// Readings receives every new fingerstick; it is closed when Listen
+	// returns
+	Readings chan *MeterReading
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMeterListener creates a listener for the meters with the given IDs,
+// or for any meter if none are given
+func NewMeterListener(crl PacketRadio, meterIDs ...string) (*MeterListener, error) {
+	ids := make(map[string]bool)
+	for _, meterID := range meterIDs {
+		id, err := ParsePumpID(meterID)
+		if err != nil {
+			return nil, err
+		}
+		ids[hex.EncodeToString(id)] = true
+	}
+	return &MeterListener{
+		rileylink: crl,
+		meterIDs:  ids,
+		Readings:  make(chan *MeterReading),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// Listen receives fingersticks until Stop is called or the RileyLink
+// fails, polling in slices of timeout
+func (ml *MeterListener) Listen(timeout time.Duration) error {
+	defer close(ml.Readings)
+	var last *MeterReading
+	for {
+		select {
+		case <-ml.stop:
+			return nil
+		default:
+		}
+		response, err := ml.rileylink.GetPacket(RLPCMeter, timeout)
+		if err != nil {
+			return err
+		} else if response.Result != RLRSuccess || len(response.Payload) < 2 {
+			continue
+		}
+		reading, err := DecodeMeterPacket(Decode4b6b(response.Payload[2:]))
+		if err != nil {
+			log.WithField("err", err).Debug("Meter: undecodable packet")
+			continue
+		} else if len(ml.meterIDs) > 0 && !ml.meterIDs[reading.MeterID] {
+			continue
+		}
+		reading.Received = time.Now()
+		reading.RSSI = decodeCCRSSI(response.Payload[0])
+		if last != nil && last.MeterID == reading.MeterID && last.Glucose == reading.Glucose &&
+			last.Ack == reading.Ack && reading.Received.Sub(last.Received) < meterRepeatWindow {
+			continue
+		}
+		last = reading
+		select {
+		case ml.Readings <- reading:
+		case <-ml.stop:
+			return nil
+		}
+	}
+}
+
+// Stop makes Listen return after the current poll; it may be called more
+// than once
+func (ml *MeterListener) Stop() {
+	ml.stopOnce.Do(func() { close(ml.stop) })
+}
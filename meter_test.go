@@ -0,0 +1,23 @@
+// meter_test.go contains tests of listening to linked glucose meters
+
+package gorileylink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeterListenerStop(t *testing.T) {
+	ml, err := NewMeterListener(&sendingRadio{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml.Stop()
+	ml.Stop()
+	if err = ml.Listen(time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if _, open := <-ml.Readings; open {
+		t.Error("readings left open")
+	}
+}
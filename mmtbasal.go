@@ -0,0 +1,158 @@
+// mmtbasal.go contains the basal schedules kept in the pump's profiles
+
+package gorileylink
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// profiles are at most 48 half-hour segments of 3 bytes each
+	mmtBasalScheduleSlots  = 48
+	mmtBasalScheduleLength = mmtBasalScheduleSlots * 3
+	mmtBasalSlotDuration   = 30 * time.Minute
+)
+
+// BasalScheduleEntry is a basal rate that starts at a time of day
+type BasalScheduleEntry struct {
+	// Start is the time since midnight, in 30 minute steps
+	Start time.Duration
+	// Rate is in U/h
+	Rate float64
+}
+
+// BasalSchedule is a day's basal rates, ordered by start time
+type BasalSchedule []BasalScheduleEntry
+
+// Validate checks the schedule can be programmed into a pump with the
+// given max basal rate (U/h) and basal rate increment (U/h)
+func (bs BasalSchedule) Validate(maxBasal float64, increment float64) error {
+	if len(bs) == 0 {
+		return fmt.Errorf("basal schedule is empty")
+	} else if len(bs) > mmtBasalScheduleSlots {
+		return fmt.Errorf("basal schedule has %d entries, at most %d allowed", len(bs), mmtBasalScheduleSlots)
+	} else if bs[0].Start != 0 {
+		return fmt.Errorf("basal schedule must start at midnight")
+	}
+	for i, entry := range bs {
+		if entry.Start%mmtBasalSlotDuration != 0 {
+			return fmt.Errorf("entry %d: start %v is not on a half hour", i, entry.Start)
+		} else if entry.Start >= 24*time.Hour {
+			return fmt.Errorf("entry %d: start %v is past the end of the day", i, entry.Start)
+		} else if i > 0 && entry.Start <= bs[i-1].Start {
+			return fmt.Errorf("entry %d: start %v is out of order", i, entry.Start)
+		} else if entry.Rate < 0 || entry.Rate > maxBasal {
+			return fmt.Errorf("entry %d: rate %v U/h is outside 0-%v U/h", i, entry.Rate, maxBasal)
+		}
+		steps := entry.Rate / increment
+		if math.Abs(steps-math.Round(steps)) > 1e-6 {
+			return fmt.Errorf("entry %d: rate %v U/h is not a multiple of %v U/h", i, entry.Rate, increment)
+		}
+	}
+	return nil
+}
+
+// encode packs the schedule as rate strokes (2 bytes, little endian) in
+// the pump's basal precision and half-hour slot.  A short schedule is
+// terminated by an empty slot
+func (bs BasalSchedule) encode(pump *MedtronicPump) []byte {
+	strokesPerUnit := float64(pump.BasalStrokesPerUnit())
+	data := make([]byte, mmtBasalScheduleLength)
+	for i, entry := range bs {
+		strokes := int(math.Round(entry.Rate * strokesPerUnit))
+		data[i*3] = byte(strokes)
+		data[i*3+1] = byte(strokes >> 8)
+		data[i*3+2] = byte(entry.Start / mmtBasalSlotDuration)
+	}
+	if len(bs) < mmtBasalScheduleSlots {
+		data[len(bs)*3+2] = 0x3f
+	}
+	return data
+}
+
+// decodeBasalSchedule unpacks a profile in the pump's basal precision; the
+// schedule ends where the slots stop increasing
+func decodeBasalSchedule(data []byte, pump *MedtronicPump) BasalSchedule {
+	strokesPerUnit := float64(pump.BasalStrokesPerUnit())
+	var schedule BasalSchedule
+	for i := 0; i+3 <= len(data) && i < mmtBasalScheduleLength; i += 3 {
+		strokes := int(data[i]) | int(data[i+1])<<8
+		start := time.Duration(data[i+2]) * mmtBasalSlotDuration
+		if start >= 24*time.Hour {
+			break
+		} else if len(schedule) > 0 && start <= schedule[len(schedule)-1].Start {
+			break
+		}
+		schedule = append(schedule, BasalScheduleEntry{
+			Start: start,
+			Rate:  float64(strokes) / strokesPerUnit,
+		})
+	}
+	return schedule
+}
+
+// Rate returns the scheduled basal rate at a time of day
+func (bs BasalSchedule) Rate(sinceMidnight time.Duration) float64 {
+	var rate float64
+	for _, entry := range bs {
+		if entry.Start > sinceMidnight {
+			break
+		}
+		rate = entry.Rate
+	}
+	return rate
+}
+
+func basalProfileCommands(profile MMTBasalProfile) (CarelinkMessageType, CarelinkMessageType, error) {
+	switch profile {
+	case MMTBasalProfileStandard:
+		return CMTReadProfileSTD512, CMTSetBasalProfileStandard, nil
+	case MMTBasalProfileA:
+		return CMTReadProfileA512, CMTSetBasalProfileA, nil
+	case MMTBasalProfileB:
+		return CMTReadProfileB512, CMTSetBasalProfileB, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown basal profile: %v", profile)
+	}
+}
+
+// ReadBasalSchedule returns the schedule stored in one of the profiles
+func (mps *MMTPumpSession) ReadBasalSchedule(profile MMTBasalProfile) (BasalSchedule, error) {
+	readCmd, _, err := basalProfileCommands(profile)
+	if err != nil {
+		return nil, err
+	}
+	err = mps.ensureModel()
+	if err != nil {
+		return nil, err
+	}
+	data, err := mps.readFrames(readCmd, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBasalSchedule(data, mps.Pump), nil
+}
+
+// WriteBasalSchedule replaces the schedule in one of the profiles, after
+// checking it against the pump's max basal rate and basal increment
+func (mps *MMTPumpSession) WriteBasalSchedule(profile MMTBasalProfile, schedule BasalSchedule) error {
+	_, writeCmd, err := basalProfileCommands(profile)
+	if err != nil {
+		return err
+	}
+	settings, err := mps.ReadSettings()
+	if err != nil {
+		return err
+	}
+	caps, ok := mps.Pump.Capabilities()
+	if !ok {
+		return fmt.Errorf("unknown pump model: %d", mps.Pump.ModelNumber)
+	}
+	err = schedule.Validate(settings.MaxBasal, caps.BasalIncrement)
+	if err != nil {
+		return err
+	}
+	return mps.writeFrames(writeCmd, schedule.encode(mps.Pump))
+}
@@ -0,0 +1,68 @@
+// mmtbasal_test.go contains tests of encoding and decoding basal schedules
+
+package gorileylink
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBasalScheduleRoundTrip(t *testing.T) {
+	for model, caps := range knownPumps {
+		pump := &MedtronicPump{ModelNumber: model}
+		schedule := BasalSchedule{
+			{Start: 0, Rate: 0.8},
+			{Start: 6 * time.Hour, Rate: 20 * caps.BasalIncrement},
+			{Start: 12*time.Hour + 30*time.Minute, Rate: 0},
+			{Start: 23*time.Hour + 30*time.Minute, Rate: caps.MaxBasalRate},
+		}
+		err := schedule.Validate(caps.MaxBasalRate, caps.BasalIncrement)
+		if err != nil {
+			t.Fatalf("%d: %v", model, err)
+		}
+		data := schedule.encode(pump)
+		if len(data) != mmtBasalScheduleLength {
+			t.Fatalf("%d: encoded to %d bytes", model, len(data))
+		}
+		decoded := decodeBasalSchedule(data, pump)
+		if len(decoded) != len(schedule) {
+			t.Fatalf("%d: decoded %v", model, decoded)
+		}
+		for i := range schedule {
+			if decoded[i] != schedule[i] {
+				t.Errorf("%d: entry %d decoded as %+v, not %+v", model, i, decoded[i], schedule[i])
+			}
+		}
+	}
+}
+
+func TestBasalScheduleEncoding(t *testing.T) {
+	schedule := BasalSchedule{
+		{Start: 0, Rate: 1},
+		{Start: 7 * time.Hour, Rate: 1.35},
+	}
+	for _, model := range []int{522, 554} {
+		data := schedule.encode(&MedtronicPump{ModelNumber: model})
+		// 1 U/h from slot 0, 1.35 U/h from slot 14, then the terminator
+		want := []byte{0x28, 0x00, 0x00, 0x36, 0x00, 0x0e, 0x00, 0x00, 0x3f}
+		if !bytes.Equal(data[:len(want)], want) {
+			t.Errorf("%d: encoded as %x", model, data[:len(want)])
+		}
+	}
+}
+
+func TestBasalScheduleValidate(t *testing.T) {
+	for _, schedule := range []BasalSchedule{
+		{},
+		{{Start: time.Hour, Rate: 1}},
+		{{Start: 0, Rate: 1}, {Start: 15 * time.Minute, Rate: 1}},
+		{{Start: 0, Rate: 1}, {Start: 0, Rate: 2}},
+		{{Start: 0, Rate: 5}},
+		{{Start: 0, Rate: 1.025}},
+	} {
+		if schedule.Validate(4, 0.05) == nil {
+			t.Errorf("%+v validated", schedule)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// mmtbolus.go contains bolus delivery, which is deliberately hard to call
+
+package gorileylink
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MMTBolusConfirmation is called with the final, validated bolus right
+// before it is transmitted; nothing is delivered unless it returns true
+type MMTBolusConfirmation func(units float64, pump *MedtronicPump) bool
+
+// encodeBolus packs a bolus in the pump's strokes.  Modern pumps take two
+// bytes of 1/40 U strokes, but larger boluses must be in coarser steps
+// as the pump scrolls faster through them
+func encodeBolus(units float64, pump *MedtronicPump) ([]byte, error) {
+	strokesPerUnit := pump.StrokesPerUnit()
+	scrollRate := 1
+	if strokesPerUnit >= 40 {
+		if units > 10 {
+			scrollRate = 4
+		} else if units > 1 {
+			scrollRate = 2
+		}
+	}
+	exact := units * float64(strokesPerUnit) / float64(scrollRate)
+	if math.Abs(exact-math.Round(exact)) > 1e-6 {
+		return nil, fmt.Errorf("bolus of %v U is not a multiple of %v U", units, float64(scrollRate)/float64(strokesPerUnit))
+	}
+	strokes := int(math.Round(exact)) * scrollRate
+	if strokesPerUnit >= 40 {
+		return []byte{byte(strokes >> 8), byte(strokes)}, nil
+	}
+	return []byte{byte(strokes)}, nil
+}
+
+// Bolus delivers units of insulin.  It refuses unless the bolus is within
+// both maxBolus and the pump's own max bolus, the pump is idle, and
+// confirm approves it.  The bolus is transmitted exactly once; if the
+// pump's answer leaves it unclear whether it was delivered (a lost reply,
+// or the error x15 pumps answer with even when the bolus goes through),
+// delivery is confirmed from history or a large enough reservoir drop, and
+// otherwise an error says delivery is unknown: history must then be checked
+// before bolusing again
+func (mps *MMTPumpSession) Bolus(units float64, maxBolus float64, confirm MMTBolusConfirmation) error {
+	if confirm == nil {
+		return fmt.Errorf("bolus refused: no confirmation callback")
+	} else if maxBolus <= 0 {
+		return fmt.Errorf("bolus refused: no max bolus limit")
+	} else if units <= 0 || units > maxBolus {
+		return fmt.Errorf("bolus refused: %v U is outside 0-%v U", units, maxBolus)
+	}
+	settings, err := mps.ReadSettings()
+	if err != nil {
+		return err
+	} else if units > settings.MaxBolus {
+		return fmt.Errorf("bolus refused: %v U is over the pump's max bolus of %v U", units, settings.MaxBolus)
+	}
+	params, err := encodeBolus(units, mps.Pump)
+	if err != nil {
+		return fmt.Errorf("bolus refused: %v", err)
+	}
+	// older pumps can't report status; they refuse a bolus themselves
+	// while suspended or bolusing
+	if mps.Pump.Supports(CMTReadPumpStatus) {
+		status, err := mps.ReadPumpStatus()
+		if err != nil {
+			return err
+		} else if status.Bolusing {
+			return fmt.Errorf("bolus refused: pump is already bolusing")
+		} else if status.Suspended {
+			return fmt.Errorf("bolus refused: pump is suspended")
+		}
+	}
+	reservoir, err := mps.ReadRemainingInsulin()
+	if err != nil {
+		return err
+	} else if reservoir.Units < units {
+		return fmt.Errorf("bolus refused: only %v U left in the reservoir", reservoir.Units)
+	}
+	pumpTime, err := mps.ReadTime()
+	if err != nil {
+		return err
+	}
+	if !confirm(units, mps.Pump) {
+		return fmt.Errorf("bolus refused: not confirmed")
+	}
+
+	log.WithField("units", units).Info("delivering bolus")
+	err = mps.deliverCommand(CMTBolus, params)
+	if err == nil {
+		return nil
+	} else if _, refused := err.(*MMTPumpError); refused && !mps.Pump.HasBolusErrorQuirk() {
+		return err
+	}
+	// never resend: find out whether the bolus was delivered anyway
+	log.WithField("err", err).Debug("bolus error, checking delivery")
+	cerr := mps.confirmBolus(units, pumpTime, reservoir)
+	if cerr != nil {
+		return fmt.Errorf("bolus delivery unknown, check history before bolusing again: %v (%v)", err, cerr)
+	}
+	return nil
+}
+
+// confirmBolus looks for evidence that a bolus went through: a normal
+// bolus of the same size recorded in history since the pump clock read
+// pumpTime, or the reservoir having dropped by at least the bolus since it
+// read before
+func (mps *MMTPumpSession) confirmBolus(units float64, pumpTime time.Time, before *MMTReservoir) error {
+	page, err := mps.GetHistoryPage(0)
+	if err == nil {
+		for _, event := range page.Events(mps.Location) {
+			bolus, ok := event.(*MMTBolusEvent)
+			if ok && bolus.Duration == 0 && math.Abs(bolus.Programmed-units) < 1e-6 &&
+				!bolus.Timestamp.Before(pumpTime) {
+				log.WithField("bolus", bolus).Debug("bolus confirmed from history")
+				return nil
+			}
+		}
+		err = fmt.Errorf("no bolus of %v U in history", units)
+	}
+	after, rerr := mps.ReadRemainingInsulin()
+	if rerr != nil {
+		return fmt.Errorf("%v; reservoir: %v", err, rerr)
+	} else if before.Units-after.Units >= units-1e-6 {
+		log.WithFields(log.Fields{
+			"before": before.Units,
+			"after":  after.Units,
+		}).Debug("bolus confirmed from reservoir")
+		return nil
+	}
+	return fmt.Errorf("%v; reservoir only dropped from %v to %v U", err, before.Units, after.Units)
+}
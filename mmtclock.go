@@ -0,0 +1,106 @@
+// mmtclock.go contains reading, setting and checking the pump clock
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MMTClockDrift is the outcome of comparing the pump and host clocks
+type MMTClockDrift struct {
+	PumpTime time.Time
+	HostTime time.Time
+	// Drift is how far the pump is ahead of the host (negative if behind)
+	Drift     time.Duration
+	Corrected bool
+}
+
+// decodePumpTime unpacks hour, minute, second, year (2 bytes), month, day
+func decodePumpTime(data []byte, loc *time.Location) (time.Time, error) {
+	if len(data) < 7 {
+		return time.Time{}, fmt.Errorf("short pump time: %x", data)
+	}
+	month := time.Month(data[5])
+	if month < time.January || month > time.December || data[6] < 1 || data[6] > 31 || data[0] > 23 || data[1] > 59 || data[2] > 59 {
+		return time.Time{}, fmt.Errorf("invalid pump time: %x", data)
+	}
+	return time.Date(
+		int(binary.BigEndian.Uint16(data[3:5])),
+		month,
+		int(data[6]),
+		int(data[0]),
+		int(data[1]),
+		int(data[2]),
+		0,
+		loc), nil
+}
+
+// encodePumpTime packs a time in the pump's hour-first layout
+func encodePumpTime(t time.Time) []byte {
+	data := make([]byte, 7)
+	data[0] = byte(t.Hour())
+	data[1] = byte(t.Minute())
+	data[2] = byte(t.Second())
+	binary.BigEndian.PutUint16(data[3:5], uint16(t.Year()))
+	data[5] = byte(t.Month())
+	data[6] = byte(t.Day())
+	return data
+}
+
+// ReadTime returns the pump clock, which is local time in the session's
+// Location
+func (mps *MMTPumpSession) ReadTime() (time.Time, error) {
+	body, err := mps.readCommand(CMTReadTime)
+	if err != nil {
+		return time.Time{}, err
+	} else if len(body) < 1 {
+		return time.Time{}, fmt.Errorf("short time reply: %x", body)
+	}
+	return decodePumpTime(body[1:], mps.Location)
+}
+
+// SetTime sets the pump clock, converting to the session's Location first
+func (mps *MMTPumpSession) SetTime(t time.Time) error {
+	return mps.setCommand(CMTChangeTime, encodePumpTime(t.In(mps.Location)))
+}
+
+// CheckClock reads the pump clock and compares it with the host's.  If
+// correct is set and the drift exceeds threshold either way, the pump is
+// set to host time
+func (mps *MMTPumpSession) CheckClock(threshold time.Duration, correct bool) (*MMTClockDrift, error) {
+	// wake first so the wakeup doesn't count against the measurement
+	err := mps.Wakeup()
+	if err != nil {
+		return nil, err
+	}
+	before := time.Now()
+	pumpTime, err := mps.ReadTime()
+	if err != nil {
+		return nil, err
+	}
+	after := time.Now()
+	// the pump answered somewhere in between
+	hostTime := before.Add(after.Sub(before) / 2).In(mps.Location)
+	drift := &MMTClockDrift{
+		PumpTime: pumpTime,
+		HostTime: hostTime,
+		Drift:    pumpTime.Sub(hostTime),
+	}
+	log.WithFields(log.Fields{
+		"pumpTime": pumpTime,
+		"hostTime": hostTime,
+		"drift":    drift.Drift,
+	}).Debug("CheckClock")
+	if correct && (drift.Drift > threshold || drift.Drift < -threshold) {
+		err = mps.SetTime(time.Now().Round(time.Second))
+		if err != nil {
+			return drift, err
+		}
+		drift.Corrected = true
+	}
+	return drift, nil
+}
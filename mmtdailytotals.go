@@ -0,0 +1,197 @@
+// mmtdailytotals.go contains per-day summaries of insulin, carbs and BG
+
+package gorileylink
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MMTDailyTotals is a day's insulin, carbs and BG as worked out from
+// history
+type MMTDailyTotals struct {
+	// Date is midnight at the start of the day
+	Date time.Time
+	// Basal and Bolus are the insulin delivered, in U.  Basal is only
+	// counted from the first MMTBasalProfileStartEvent on
+	Basal float64
+	Bolus float64
+	// Carbs is what was entered, in the pump's carb units
+	Carbs int
+	// BGCount and BGAverage (mg/dL) cover the BGs entered or received
+	BGCount   int
+	BGAverage float64
+	// PumpTotal is the pump's own count of the day's insulin in U, where
+	// it recorded one
+	PumpTotal float64
+	// Summary is the pump's own summary of the day, split by basal and
+	// bolus, where it recorded one
+	Summary *MMTDailyTotalEvent
+}
+
+// Total returns the day's insulin in U
+func (mdt *MMTDailyTotals) Total() float64 {
+	return mdt.Basal + mdt.Bolus
+}
+
+// dailyTotalsBuilder accumulates events into days
+type dailyTotalsBuilder struct {
+	days  map[time.Time]*MMTDailyTotals
+	bgSum map[time.Time]int
+}
+
+// day returns the totals for the day t falls in
+func (dtb *dailyTotalsBuilder) day(t time.Time) *MMTDailyTotals {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	totals, ok := dtb.days[midnight]
+	if !ok {
+		totals = &MMTDailyTotals{Date: midnight}
+		dtb.days[midnight] = totals
+	}
+	return totals
+}
+
+// basal adds delivery at rate (U/h) from one time to another, split at
+// midnights
+func (dtb *dailyTotalsBuilder) basal(from time.Time, to time.Time, rate float64) {
+	for from.Before(to) {
+		totals := dtb.day(from)
+		end := totals.Date.AddDate(0, 0, 1)
+		if end.After(to) {
+			end = to
+		}
+		totals.Basal += rate * end.Sub(from).Hours()
+		from = end
+	}
+}
+
+// DailyTotals works out per-day totals from decoded history events, as
+// from consecutive pages of MMTHistoryPage.Events, oldest first.  Basal
+// delivery is reconstructed from scheduled rates, temp basals and
+// suspends, so days at the edges of the history are incomplete
+func DailyTotals(events []MMTHistoryEvent) []MMTDailyTotals {
+	var timed []MMTHistoryEvent
+	for _, event := range events {
+		if !event.Record().Timestamp.IsZero() {
+			timed = append(timed, event)
+		}
+	}
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].Record().Timestamp.Before(timed[j].Record().Timestamp)
+	})
+
+	dtb := &dailyTotalsBuilder{
+		days:  make(map[time.Time]*MMTDailyTotals),
+		bgSum: make(map[time.Time]int),
+	}
+	var (
+		last      time.Time
+		scheduled = -1.0
+		suspended bool
+		temp      *MMTTempBasalEvent
+		tempEnd   time.Time
+	)
+	rate := func() float64 {
+		switch {
+		case suspended:
+			return 0
+		case temp == nil:
+			return scheduled
+		case temp.RateType == MMTTempBasalPercent:
+			return scheduled * float64(temp.Percent) / 100
+		default:
+			return temp.Rate
+		}
+	}
+	for _, event := range timed {
+		record := event.Record()
+		now := record.Timestamp
+		if scheduled >= 0 {
+			if temp != nil && !tempEnd.IsZero() && !tempEnd.After(now) {
+				dtb.basal(last, tempEnd, rate())
+				last, temp = tempEnd, nil
+			}
+			dtb.basal(last, now, rate())
+		}
+		last = now
+
+		switch e := event.(type) {
+		case *MMTBasalProfileStartEvent:
+			scheduled = e.Rate
+		case *MMTTempBasalEvent:
+			// the duration follows in a record of its own
+			temp = e
+			tempEnd = time.Time{}
+		case *MMTTempBasalDurationEvent:
+			if temp != nil {
+				tempEnd = now.Add(e.Duration)
+			}
+		case *MMTBolusEvent:
+			dtb.day(now).Bolus += e.Amount
+		case *MMTBolusWizardEvent:
+			dtb.day(now).Carbs += e.Carbs
+		case *MMTJournalEntryEvent:
+			dtb.day(now).Carbs += e.Carbs
+		case *MMTBGEvent:
+			if e.BG > 0 {
+				totals := dtb.day(now)
+				totals.BGCount++
+				dtb.bgSum[totals.Date] += e.BG
+			}
+		case *MMTDailyTotalEvent:
+			totals := dtb.day(now)
+			if e.Total > 0 {
+				totals.PumpTotal = e.Total
+			}
+			if e.Type != MMTRecordResultDailyTotal {
+				totals.Summary = e
+			}
+		default:
+			switch record.Type {
+			case MMTRecordSuspend:
+				suspended = true
+			case MMTRecordResume:
+				suspended = false
+			}
+		}
+	}
+
+	totals := make([]MMTDailyTotals, 0, len(dtb.days))
+	for date, day := range dtb.days {
+		if day.BGCount > 0 {
+			day.BGAverage = float64(dtb.bgSum[date]) / float64(day.BGCount)
+		}
+		totals = append(totals, *day)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Date.Before(totals[j].Date)
+	})
+	return totals
+}
+
+// MMTTodaysTotals is the insulin delivered today and yesterday, in U
+type MMTTodaysTotals struct {
+	Today     float64
+	Yesterday float64
+}
+
+// ReadTodaysTotals returns the pump's running insulin totals
+func (mps *MMTPumpSession) ReadTodaysTotals() (*MMTTodaysTotals, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	} else if !mps.Pump.Supports(CMTReadTodaysTotals) {
+		return nil, fmt.Errorf("pump model %d can't report daily totals", mps.Pump.ModelNumber)
+	}
+	body, err := mps.readCommand(CMTReadTodaysTotals)
+	if err != nil {
+		return nil, err
+	} else if len(body) < 5 {
+		return nil, fmt.Errorf("short daily totals reply: %x", body)
+	}
+	return &MMTTodaysTotals{
+		float64(int(body[1])<<8|int(body[2])) / 10,
+		float64(int(body[3])<<8|int(body[4])) / 10,
+	}, nil
+}
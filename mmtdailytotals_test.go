@@ -0,0 +1,58 @@
+// mmtdailytotals_test.go contains tests of decoding the pump's daily
+// summaries and working out daily totals
+
+package gorileylink
+
+import (
+	"testing"
+	"time"
+)
+
+// summaries for 2015-09-05 of 120 mg/dL average over 3 BGs, 100 g of
+// carbs, and 26.5 U of insulin of which 22.3 U basal and 4.2 U bolus.  The
+// 522's is the 515's with six more bytes at the end, which aren't decoded;
+// the 523 and newer pack the basal and bolus up against the total
+const (
+	dailyTotal515Record = "6c858f" + "007800000300006404240000037c000000a8" +
+		"0000000000000000000000000000000000"
+	dailyTotal522Record = "6d858f" + "007800000300006404240000037c000000a8" +
+		"0000000000000000000000000000000000000000000000"
+	dailyTotal523Record = "6e858f" + "00780000030000640424037c00a8" +
+		"0000000000000000000000000000000000000000000000000000000000000000000000"
+)
+
+func TestDecodeDailyTotalRecords(t *testing.T) {
+	for _, tc := range []struct {
+		model  int
+		rt     MMTHistoryRecordType
+		record string
+	}{
+		{515, MMTRecordDailyTotal515, dailyTotal515Record},
+		{522, MMTRecordDailyTotal522, dailyTotal522Record},
+		{554, MMTRecordDailyTotal523, dailyTotal523Record},
+	} {
+		pump := &MedtronicPump{ModelNumber: tc.model}
+		events := DecodeHistoryPage(mustDecodeHex(t, tc.record), pump, time.UTC)
+		if len(events) != 1 {
+			t.Fatalf("%v: decoded %d events", tc.rt, len(events))
+		}
+		total, ok := events[0].(*MMTDailyTotalEvent)
+		if !ok {
+			t.Fatalf("%v: decoded as %T", tc.rt, events[0])
+		}
+		if want := time.Date(2015, 9, 5, 0, 0, 0, 0, time.UTC); !total.Timestamp.Equal(want) {
+			t.Errorf("%v: dated %v", tc.rt, total.Timestamp)
+		}
+		if total.Total != 26.5 || total.Basal != 22.3 || total.Bolus != 4.2 {
+			t.Errorf("%v: insulin %v = %v + %v U", tc.rt, total.Total, total.Basal, total.Bolus)
+		}
+		if total.Carbs != 100 || total.BGCount != 3 || total.BGAverage != 120 {
+			t.Errorf("%v: carbs %d, %d BGs averaging %d", tc.rt, total.Carbs, total.BGCount, total.BGAverage)
+		}
+
+		days := DailyTotals(events)
+		if len(days) != 1 || days[0].Summary != total || days[0].PumpTotal != 26.5 {
+			t.Errorf("%v: daily totals %+v", tc.rt, days)
+		}
+	}
+}
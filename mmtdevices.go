@@ -0,0 +1,204 @@
+// mmtdevices.go contains the remotes and other devices paired with a pump
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	// pumps hold up to three remote IDs of six digits each
+	mmtRemoteSlots    = 3
+	mmtRemoteIDLength = 6
+	// other devices are listed as a count, a byte of unknown meaning, and
+	// 5-byte entries, ID first
+	mmtOtherDevicesStart   = 3
+	mmtOtherDeviceLength   = 5
+	mmtOtherDeviceIDLength = 4
+)
+
+// MMTRemoteControls is what the pump knows of its RF remotes
+type MMTRemoteControls struct {
+	// IDs holds the six-digit ID in each slot, empty when unused
+	IDs [mmtRemoteSlots]string
+}
+
+// ParseRemoteID checks a remote ID is six digits and packs it a digit a
+// byte, as the pump keeps it
+func ParseRemoteID(remoteID string) ([]byte, error) {
+	if len(remoteID) != mmtRemoteIDLength {
+		return nil, fmt.Errorf("remote ID %q is not %d digits", remoteID, mmtRemoteIDLength)
+	}
+	digits := make([]byte, mmtRemoteIDLength)
+	for i, c := range []byte(remoteID) {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("remote ID %q is not %d digits", remoteID, mmtRemoteIDLength)
+		}
+		digits[i] = c - '0'
+	}
+	return digits, nil
+}
+
+// decodeRemoteID unpacks a digit-a-byte ID; anything that isn't digits is
+// an empty slot
+func decodeRemoteID(data []byte) string {
+	id := make([]byte, len(data))
+	for i, digit := range data {
+		if digit > 9 {
+			return ""
+		}
+		id[i] = '0' + digit
+	}
+	return string(id)
+}
+
+// decodeRemoteControlIDs unpacks the remote slots, which follow the
+// length byte
+func decodeRemoteControlIDs(body []byte) (*MMTRemoteControls, error) {
+	if len(body) < 1+mmtRemoteSlots*mmtRemoteIDLength {
+		return nil, fmt.Errorf("short remote control IDs reply: %x", body)
+	}
+	remotes := &MMTRemoteControls{}
+	for i := range remotes.IDs {
+		start := 1 + i*mmtRemoteIDLength
+		remotes.IDs[i] = decodeRemoteID(body[start : start+mmtRemoteIDLength])
+	}
+	return remotes, nil
+}
+
+// ReadRemoteControlIDs returns the remotes the pump is paired with
+func (mps *MMTPumpSession) ReadRemoteControlIDs() (*MMTRemoteControls, error) {
+	body, err := mps.readCommand(CMTReadRemoteControlIDs)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRemoteControlIDs(body)
+}
+
+// SetRemoteControlID writes a remote ID into one of the pump's slots
+func (mps *MMTPumpSession) SetRemoteControlID(slot int, remoteID string) error {
+	if slot < 0 || slot >= mmtRemoteSlots {
+		return fmt.Errorf("remote slot %d is outside 0-%d", slot, mmtRemoteSlots-1)
+	}
+	digits, err := ParseRemoteID(remoteID)
+	if err != nil {
+		return err
+	}
+	return mps.setCommand(CMTSetRemoteControlID, append([]byte{byte(slot)}, digits...))
+}
+
+// RemoveRemoteControlID empties one of the pump's remote slots
+func (mps *MMTPumpSession) RemoveRemoteControlID(slot int) error {
+	if slot < 0 || slot >= mmtRemoteSlots {
+		return fmt.Errorf("remote slot %d is outside 0-%d", slot, mmtRemoteSlots-1)
+	}
+	params := []byte{byte(slot), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	return mps.setCommand(CMTSetRemoteControlID, params)
+}
+
+// AddRemoteControl pairs a remote in the first free slot, and reads the
+// slots back to make sure it took
+func (mps *MMTPumpSession) AddRemoteControl(remoteID string) error {
+	remotes, err := mps.ReadRemoteControlIDs()
+	if err != nil {
+		return err
+	}
+	slot := -1
+	for i, id := range remotes.IDs {
+		if id == remoteID {
+			return nil
+		} else if id == "" && slot < 0 {
+			slot = i
+		}
+	}
+	if slot < 0 {
+		return fmt.Errorf("no free remote slot for %s", remoteID)
+	}
+	err = mps.SetRemoteControlID(slot, remoteID)
+	if err != nil {
+		return err
+	}
+	remotes, err = mps.ReadRemoteControlIDs()
+	if err != nil {
+		return err
+	} else if remotes.IDs[slot] != remoteID {
+		return fmt.Errorf("remote slot %d reads back as %q, not %s", slot, remotes.IDs[slot], remoteID)
+	}
+	return nil
+}
+
+// RemoveRemoteControl unpairs a remote from whichever slot holds it
+func (mps *MMTPumpSession) RemoveRemoteControl(remoteID string) error {
+	remotes, err := mps.ReadRemoteControlIDs()
+	if err != nil {
+		return err
+	}
+	for i, id := range remotes.IDs {
+		if id == remoteID {
+			return mps.RemoveRemoteControlID(i)
+		}
+	}
+	return fmt.Errorf("remote %s is not paired", remoteID)
+}
+
+// SetRemoteControlEnabled turns the pump's listening for remotes on or off
+func (mps *MMTPumpSession) SetRemoteControlEnabled(enabled bool) error {
+	param := byte(0x00)
+	if enabled {
+		param = 0x01
+	}
+	return mps.setCommand(CMTSetRemoteControlEnabled, []byte{param})
+}
+
+// ReadOtherDevicesIDs returns the IDs of the meters and sensors the pump
+// is linked with, as hex.  Linking them is done on the pump itself
+func (mps *MMTPumpSession) ReadOtherDevicesIDs() ([]string, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	} else if !mps.Pump.Supports(CMTReadOtherDevicesIDs) {
+		return nil, fmt.Errorf("pump model %d can't list linked devices", mps.Pump.ModelNumber)
+	}
+	body, err := mps.readCommand(CMTReadOtherDevicesIDs)
+	if err != nil {
+		return nil, err
+	}
+	return decodeOtherDevicesIDs(body)
+}
+
+// decodeOtherDevicesIDs unpacks the linked device list: the count is in
+// the byte after the length, and the entries start after the byte that
+// follows it, which is skipped as MinimedKit skips it
+func decodeOtherDevicesIDs(body []byte) ([]string, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("short other devices reply: %x", body)
+	}
+	var ids []string
+	for i := 0; i < int(body[1]); i++ {
+		start := mmtOtherDevicesStart + i*mmtOtherDeviceLength
+		if start+mmtOtherDeviceIDLength > len(body) {
+			return nil, fmt.Errorf("short other devices reply: %x", body)
+		}
+		ids = append(ids, hex.EncodeToString(body[start:start+mmtOtherDeviceIDLength]))
+	}
+	return ids, nil
+}
+
+// ReadOtherDevicesEnabled returns whether the pump is listening for its
+// linked devices
+func (mps *MMTPumpSession) ReadOtherDevicesEnabled() (bool, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return false, err
+	} else if !mps.Pump.Supports(CMTReadOtherDevicesStatus) {
+		return false, fmt.Errorf("pump model %d can't list linked devices", mps.Pump.ModelNumber)
+	}
+	body, err := mps.readCommand(CMTReadOtherDevicesStatus)
+	if err != nil {
+		return false, err
+	} else if len(body) < 2 {
+		return false, fmt.Errorf("short other devices status reply: %x", body)
+	}
+	return body[1] == 0x01, nil
+}
@@ -0,0 +1,43 @@
+// mmtdevices_test.go contains tests of decoding the remotes and other
+// devices paired with a pump
+
+package gorileylink
+
+import "testing"
+
+// the replies below are laid out as MinimedKit's
+// ReadRemoteControlIDsMessageBody and ReadOtherDevicesIDsMessageBody read
+// them
+
+func TestDecodeRemoteControlIDs(t *testing.T) {
+	body := mustDecodeHex(t, "13"+"010203040506"+"ffffffffffff"+"090807060504"+"000000")
+	remotes, err := decodeRemoteControlIDs(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remotes.IDs != [mmtRemoteSlots]string{"123456", "", "987654"} {
+		t.Errorf("remotes %q", remotes.IDs)
+	}
+	if _, err = decodeRemoteControlIDs(body[:18]); err == nil {
+		t.Error("short reply decoded")
+	}
+}
+
+func TestDecodeOtherDevicesIDs(t *testing.T) {
+	// the count, then a byte skipped before the entries
+	body := mustDecodeHex(t, "0c"+"02"+"00"+"a1b2c3d400"+"0102030400"+"0000")
+	ids, err := decodeOtherDevicesIDs(body)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 2 || ids[0] != "a1b2c3d4" || ids[1] != "01020304" {
+		t.Errorf("devices %q", ids)
+	}
+
+	ids, err = decodeOtherDevicesIDs(mustDecodeHex(t, "02000000"))
+	if err != nil || len(ids) != 0 {
+		t.Errorf("no devices: %q (%v)", ids, err)
+	}
+	if _, err = decodeOtherDevicesIDs(mustDecodeHex(t, "0703"+"00"+"a1b2c3d400")); err == nil {
+		t.Error("reply short of its count decoded")
+	}
+}
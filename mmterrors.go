@@ -0,0 +1,125 @@
+// mmterrors.go contains the pump's alarms and the errors it answers with
+
+package gorileylink
+
+import (
+	"fmt"
+)
+
+// MMTAlarmCode is an alarm raised by the pump
+type MMTAlarmCode byte
+
+const (
+	MMTAlarmNone                    MMTAlarmCode = 0x00
+	MMTAlarmBatteryOutLimitExceeded MMTAlarmCode = 0x03
+	MMTAlarmNoDelivery              MMTAlarmCode = 0x04
+	MMTAlarmBatteryDepleted         MMTAlarmCode = 0x05
+	MMTAlarmAutoOff                 MMTAlarmCode = 0x06
+	MMTAlarmDeviceReset             MMTAlarmCode = 0x10
+	MMTAlarmReprogramError          MMTAlarmCode = 0x3d
+	MMTAlarmEmptyReservoir          MMTAlarmCode = 0x3e
+	// MMTAlarmLowGlucoseSuspend is raised by pumps with low glucose
+	// suspend when they stop delivery themselves; the code hasn't been
+	// confirmed against a capture yet
+	MMTAlarmLowGlucoseSuspend MMTAlarmCode = 0x3f
+)
+
+func (mac MMTAlarmCode) String() string {
+	switch mac {
+	case MMTAlarmNone:
+		return "MMTAlarmNone"
+	case MMTAlarmBatteryOutLimitExceeded:
+		return "MMTAlarmBatteryOutLimitExceeded"
+	case MMTAlarmNoDelivery:
+		return "MMTAlarmNoDelivery"
+	case MMTAlarmBatteryDepleted:
+		return "MMTAlarmBatteryDepleted"
+	case MMTAlarmAutoOff:
+		return "MMTAlarmAutoOff"
+	case MMTAlarmDeviceReset:
+		return "MMTAlarmDeviceReset"
+	case MMTAlarmReprogramError:
+		return "MMTAlarmReprogramError"
+	case MMTAlarmEmptyReservoir:
+		return "MMTAlarmEmptyReservoir"
+	case MMTAlarmLowGlucoseSuspend:
+		return "MMTAlarmLowGlucoseSuspend"
+	default:
+		return "MMTAlarmCodeUNKNOWN"
+	}
+}
+
+// Known returns whether the alarm is one this package knows
+func (mac MMTAlarmCode) Known() bool {
+	return mac.String() != "MMTAlarmCodeUNKNOWN"
+}
+
+// MMTErrorCode is why the pump refused a command
+type MMTErrorCode byte
+
+const (
+	// MMTErrorCommandRefused happens when the pump is suspended, priming
+	// or set to the wrong temp basal type
+	MMTErrorCommandRefused     MMTErrorCode = 0x08
+	MMTErrorMaxSettingExceeded MMTErrorCode = 0x09
+	MMTErrorBolusInProgress    MMTErrorCode = 0x0c
+	MMTErrorPageDoesNotExist   MMTErrorCode = 0x0d
+)
+
+func (mec MMTErrorCode) String() string {
+	switch mec {
+	case MMTErrorCommandRefused:
+		return "MMTErrorCommandRefused"
+	case MMTErrorMaxSettingExceeded:
+		return "MMTErrorMaxSettingExceeded"
+	case MMTErrorBolusInProgress:
+		return "MMTErrorBolusInProgress"
+	case MMTErrorPageDoesNotExist:
+		return "MMTErrorPageDoesNotExist"
+	default:
+		return "MMTErrorCodeUNKNOWN"
+	}
+}
+
+// MMTPumpError is the pump answering a command with CMTErrorResponse
+type MMTPumpError struct {
+	Command CarelinkMessageType
+	Code    MMTErrorCode
+	Data    []byte
+}
+
+func (mpe *MMTPumpError) Error() string {
+	return fmt.Sprintf("pump refused %#x: %v (%#x)", byte(mpe.Command), mpe.Code, byte(mpe.Code))
+}
+
+// decodePumpError unpacks a CMTErrorResponse body, which carries the
+// error code in its first byte with no length before it
+func decodePumpError(cmt CarelinkMessageType, body []byte) *MMTPumpError {
+	mpe := &MMTPumpError{Command: cmt, Data: body}
+	if len(body) > 0 {
+		mpe.Code = MMTErrorCode(body[0])
+	}
+	return mpe
+}
+
+// IsPumpError returns whether err is the pump refusing with the given code
+func IsPumpError(err error, code MMTErrorCode) bool {
+	mpe, ok := err.(*MMTPumpError)
+	return ok && mpe.Code == code
+}
+
+// ReadErrorStatus returns the alarm the pump is currently raising, if any
+func (mps *MMTPumpSession) ReadErrorStatus() (MMTAlarmCode, error) {
+	body, err := mps.readCommand(CMTReadErrorStatus)
+	if err != nil {
+		return MMTAlarmNone, err
+	} else if len(body) < 2 {
+		return MMTAlarmNone, fmt.Errorf("short error status reply: %x", body)
+	}
+	return MMTAlarmCode(body[1]), nil
+}
+
+// ClearAlert acknowledges an alarm on the pump, as pressing ESC/ACT would
+func (mps *MMTPumpSession) ClearAlert(code MMTAlarmCode) error {
+	return mps.setCommand(CMTAlertCleared, []byte{byte(code)})
+}
@@ -0,0 +1,35 @@
+// mmterrors_test.go contains tests of decoding the errors a pump refuses
+// commands with
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDecodePumpError(t *testing.T) {
+	// 0x15 replies from pump 594040, laid out as MinimedKit's
+	// PumpErrorMessageBody reads them: the code at byte 0, no length
+	for _, tc := range []struct {
+		packet string
+		code   MMTErrorCode
+	}{
+		{"a7594040150c78", MMTErrorBolusInProgress},
+		{"a7594040150de3", MMTErrorPageDoesNotExist},
+		// with the body zero-padded
+		{"a7594040150c000000008b", MMTErrorBolusInProgress},
+	} {
+		data, _ := hex.DecodeString(tc.packet)
+		_, msg, err := ParseCarelinkPacket(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.packet, err)
+		} else if msg.MessageType != CMTErrorResponse {
+			t.Fatalf("%s: type %v", tc.packet, msg.MessageType)
+		}
+		perr := decodePumpError(CMTBolus, msg.Data)
+		if !IsPumpError(perr, tc.code) {
+			t.Errorf("%s: %v, want %v", tc.packet, perr, tc.code)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// mmtglucose.go contains fetching and decoding the pump's CGM history
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// MMTGlucoseRecordType is the opcode of a glucose page record.  Unlike
+// pump history, the opcode is the last byte of the record, and the page
+// is read from the end backwards
+type MMTGlucoseRecordType byte
+
+const (
+	MMTGlucoseDataEnd           MMTGlucoseRecordType = 0x01
+	MMTGlucoseSensorWeakSignal  MMTGlucoseRecordType = 0x02
+	MMTGlucoseSensorCal         MMTGlucoseRecordType = 0x03
+	MMTGlucoseSensorPacket      MMTGlucoseRecordType = 0x04
+	MMTGlucoseSensorError       MMTGlucoseRecordType = 0x05
+	MMTGlucoseSensorDataLow     MMTGlucoseRecordType = 0x06
+	MMTGlucoseSensorDataHigh    MMTGlucoseRecordType = 0x07
+	MMTGlucoseSensorTimestamp   MMTGlucoseRecordType = 0x08
+	MMTGlucoseBatteryChange     MMTGlucoseRecordType = 0x0a
+	MMTGlucoseSensorStatus      MMTGlucoseRecordType = 0x0b
+	MMTGlucoseDateTimeChange    MMTGlucoseRecordType = 0x0c
+	MMTGlucoseSensorSync        MMTGlucoseRecordType = 0x0d
+	MMTGlucoseCalBGForGH        MMTGlucoseRecordType = 0x0e
+	MMTGlucoseSensorCalFactor   MMTGlucoseRecordType = 0x0f
+	MMTGlucoseTenSomething      MMTGlucoseRecordType = 0x10
+	MMTGlucoseNineteenSomething MMTGlucoseRecordType = 0x13
+	// MMTGlucoseSensorValue stands for every opcode from 0x14 up, where
+	// the opcode itself is the sensor glucose in 2 mg/dL steps
+	MMTGlucoseSensorValue MMTGlucoseRecordType = 0x14
+	// MMTGlucoseUnknown marks bytes that couldn't be decoded
+	MMTGlucoseUnknown MMTGlucoseRecordType = 0xff
+)
+
+// the time between sensor readings, which is how relative records are
+// timed from the nearest newer timestamped record
+const mmtGlucoseInterval = 5 * time.Minute
+
+// MMTGlucoseEvent is a decoded glucose page record
+type MMTGlucoseEvent struct {
+	Type MMTGlucoseRecordType
+	// Timestamp is zero if no timestamped record follows a relative one
+	Timestamp time.Time
+	// Relative is set for records timed from their neighbours
+	Relative bool
+	// Glucose is the sensor glucose (MMTGlucoseSensorValue) or the
+	// calibration BG (MMTGlucoseCalBGForGH), in mg/dL
+	Glucose int
+	// CalFactor is set on MMTGlucoseSensorCalFactor
+	CalFactor float64
+	// Subtype is the kind of timestamp, status, sync, cal or error
+	Subtype byte
+	// Data is the whole raw record, opcode last
+	Data []byte
+}
+
+// MMTSensorReading is a timestamped sensor glucose value
+type MMTSensorReading struct {
+	Timestamp time.Time
+	// Glucose is in mg/dL
+	Glucose int
+}
+
+// MMTGlucosePage is a raw page of glucose history as it was received
+type MMTGlucosePage struct {
+	Number int
+	// Data is the whole page, including the trailing CRC16
+	Data        []byte
+	PumpID      string
+	ModelNumber int
+	Fetched     time.Time
+	// Attempts is how many fetches it took to get an intact page
+	Attempts int
+}
+
+// Records returns the page without its CRC16
+func (mgp *MMTGlucosePage) Records() []byte {
+	return mgp.Data[:len(mgp.Data)-2]
+}
+
+// Events decodes the page in the time zone the pump clock is kept in
+func (mgp *MMTGlucosePage) Events(loc *time.Location) []MMTGlucoseEvent {
+	return DecodeGlucosePage(mgp.Records(), loc)
+}
+
+// glucoseRecordLength returns the length of a record including its
+// opcode, and whether it is timed relative to its neighbours
+func glucoseRecordLength(rt MMTGlucoseRecordType) (int, bool, bool) {
+	switch {
+	case rt == MMTGlucoseDataEnd:
+		return 1, false, true
+	case rt == MMTGlucoseSensorWeakSignal, rt == MMTGlucoseSensorDataLow,
+		rt == MMTGlucoseNineteenSomething, rt >= MMTGlucoseSensorValue:
+		return 1, true, true
+	case rt == MMTGlucoseSensorCal, rt == MMTGlucoseSensorPacket,
+		rt == MMTGlucoseSensorError, rt == MMTGlucoseSensorDataHigh:
+		return 2, true, true
+	case rt == MMTGlucoseSensorTimestamp, rt == MMTGlucoseBatteryChange,
+		rt == MMTGlucoseSensorStatus, rt == MMTGlucoseDateTimeChange,
+		rt == MMTGlucoseSensorSync:
+		return 5, false, true
+	case rt == MMTGlucoseCalBGForGH:
+		return 6, false, true
+	case rt == MMTGlucoseSensorCalFactor:
+		return 7, false, true
+	case rt == MMTGlucoseTenSomething:
+		return 8, false, true
+	default:
+		return 0, false, false
+	}
+}
+
+// decodeGlucoseTimestamp unpacks the 4-byte timestamp of glucose records:
+// hour, minute, day and year in the low bits, the month in the top bits
+// of the first two
+func decodeGlucoseTimestamp(data []byte, loc *time.Location) time.Time {
+	hour := int(data[0] & 0x1f)
+	minute := int(data[1] & 0x3f)
+	day := int(data[2] & 0x1f)
+	month := int(data[0]>>4&0x0c | data[1]>>6)
+	year := 2000 + int(data[3]&0x7f)
+	if month < 1 || month > 12 || day < 1 || hour > 23 || minute > 59 {
+		return time.Time{}
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, 0, 0, loc)
+}
+
+// DecodeGlucosePage turns the records of a glucose page (without its CRC)
+// into events, oldest first.  Relative records (sensor values among them)
+// are each taken to be one reading interval before the next newer record
+func DecodeGlucosePage(records []byte, loc *time.Location) []MMTGlucoseEvent {
+	var (
+		reversed []MMTGlucoseEvent
+		current  time.Time
+	)
+	end := len(records)
+	// pages are zero-padded after the last record
+	for end > 0 && records[end-1] == 0x00 {
+		end--
+	}
+	for end > 0 {
+		rt := MMTGlucoseRecordType(records[end-1])
+		length, relative, ok := glucoseRecordLength(rt)
+		if !ok || length > end {
+			reversed = append(reversed, MMTGlucoseEvent{Type: MMTGlucoseUnknown, Data: records[end-1 : end]})
+			end--
+			continue
+		}
+		data := records[end-length : end]
+		event := MMTGlucoseEvent{Type: rt, Relative: relative, Data: data}
+		switch {
+		case rt >= MMTGlucoseSensorValue:
+			event.Type = MMTGlucoseSensorValue
+			event.Glucose = int(rt) * 2
+		case length == 2:
+			event.Subtype = data[0]
+		case length >= 5:
+			ts := data[length-5 : length-1]
+			event.Timestamp = decodeGlucoseTimestamp(ts, loc)
+			event.Subtype = ts[2] >> 5 & 0x03
+			if rt == MMTGlucoseCalBGForGH {
+				event.Glucose = int(ts[2]&0x20)<<3 | int(data[0])
+			} else if rt == MMTGlucoseSensorCalFactor {
+				event.CalFactor = float64(binary.BigEndian.Uint16(data[0:2])) / 1000
+			}
+		}
+		if relative {
+			if !current.IsZero() {
+				current = current.Add(-mmtGlucoseInterval)
+				event.Timestamp = current
+			}
+		} else if !event.Timestamp.IsZero() {
+			current = event.Timestamp
+		}
+		reversed = append(reversed, event)
+		end -= length
+	}
+	events := make([]MMTGlucoseEvent, len(reversed))
+	for i, event := range reversed {
+		events[len(reversed)-1-i] = event
+	}
+	return events
+}
+
+// SensorReadings returns just the sensor glucose values that have a time
+func SensorReadings(events []MMTGlucoseEvent) []MMTSensorReading {
+	var readings []MMTSensorReading
+	for _, event := range events {
+		if event.Type == MMTGlucoseSensorValue && !event.Timestamp.IsZero() {
+			readings = append(readings, MMTSensorReading{event.Timestamp, event.Glucose})
+		}
+	}
+	return readings
+}
+
+// ReadCurrentGlucosePage returns the number of the glucose page being
+// written to
+func (mps *MMTPumpSession) ReadCurrentGlucosePage() (int, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return 0, err
+	} else if !mps.Pump.Supports(CMTReadCurrentGlucosePage) {
+		return 0, fmt.Errorf("pump model %d keeps no glucose history", mps.Pump.ModelNumber)
+	}
+	body, err := mps.readCommand(CMTReadCurrentGlucosePage)
+	if err != nil {
+		return 0, err
+	} else if len(body) < 5 {
+		return 0, fmt.Errorf("short glucose page reply: %x", body)
+	}
+	return int(binary.BigEndian.Uint32(body[1:5])), nil
+}
+
+// GetGlucosePage fetches a page of glucose history by its absolute number
+// (see ReadCurrentGlucosePage), fetching it again if it arrives corrupted
+func (mps *MMTPumpSession) GetGlucosePage(number int) (*MMTGlucosePage, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	} else if !mps.Pump.Supports(CMTGetGlucosePage) {
+		return nil, fmt.Errorf("pump model %d keeps no glucose history", mps.Pump.ModelNumber)
+	}
+	params := make([]byte, 4)
+	binary.BigEndian.PutUint32(params, uint32(number))
+	data, attempts, err := mps.fetchPage(CMTGetGlucosePage, params)
+	if _, refused := err.(*MMTPumpError); refused {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("glucose page %d: %v", number, err)
+	}
+	return &MMTGlucosePage{
+		Number:      number,
+		Data:        data,
+		PumpID:      hex.EncodeToString(mps.pumpID),
+		ModelNumber: mps.Pump.ModelNumber,
+		Fetched:     time.Now(),
+		Attempts:    attempts,
+	}, nil
+}
+
+// WriteGlucoseHistoryTimestamp has the pump write a sensor timestamp
+// record, anchoring the relative records before it
+func (mps *MMTPumpSession) WriteGlucoseHistoryTimestamp() error {
+	reply, err := mps.runCommand(CMTWriteGlucoseHistoryTimestamp, nil)
+	if err != nil {
+		return err
+	} else if reply.MessageType != CMTPumpAck {
+		return fmt.Errorf("%#x: unexpected reply %#x", CMTWriteGlucoseHistoryTimestamp, reply.MessageType)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+// mmtglucose_test.go contains tests of reading glucose history
+
+package gorileylink
+
+import "testing"
+
+func TestGlucoseUnsupported(t *testing.T) {
+	// refused before the radio, which there isn't, is ever reached
+	mps, err := NewMMTPumpSession(nil, &MedtronicPump{ModelNumber: 515}, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mps.ReadCurrentGlucosePage(); err == nil {
+		t.Error("current glucose page read from a 515")
+	}
+	if _, err = mps.GetGlucosePage(0); err == nil {
+		t.Error("glucose page read from a 515")
+	}
+}
@@ -0,0 +1,113 @@
+// mmthistory.go contains fetching the raw pages of pump history
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// a history page is 1022 bytes of records and a big-endian CRC16
+	mmtHistoryPageLength = 1024
+	// how many times a corrupted page is fetched before giving up
+	mmtHistoryPageAttempts = 3
+)
+
+// MMTHistoryPage is a raw page of pump history as it was received
+type MMTHistoryPage struct {
+	Number int
+	// Data is the whole page, including the trailing CRC16
+	Data        []byte
+	PumpID      string
+	ModelNumber int
+	Fetched     time.Time
+	// Attempts is how many fetches it took to get an intact page
+	Attempts int
+}
+
+// Records returns the page without its CRC16
+func (mhp *MMTHistoryPage) Records() []byte {
+	return mhp.Data[:len(mhp.Data)-2]
+}
+
+// checkPageCRC verifies the CRC16 at the end of a page
+func checkPageCRC(page []byte) error {
+	if len(page) < 2 {
+		return fmt.Errorf("short page: %d bytes", len(page))
+	}
+	want := binary.BigEndian.Uint16(page[len(page)-2:])
+	got := CRC16(page[:len(page)-2])
+	if got != want {
+		return fmt.Errorf("bad CRC16: %04x, expected %04x", got, want)
+	}
+	return nil
+}
+
+// ReadCurrentPageNumber returns the number of the page history is being
+// written to, which is also how many full pages there are
+func (mps *MMTPumpSession) ReadCurrentPageNumber() (int, error) {
+	body, err := mps.readCommand(CMTReadCurrentPageNumber)
+	if err != nil {
+		return 0, err
+	} else if len(body) < 5 {
+		return 0, fmt.Errorf("short page number reply: %x", body)
+	}
+	return int(binary.BigEndian.Uint32(body[1:5])), nil
+}
+
+// fetchPage fetches a page with the given command, fetching it again if
+// it arrives corrupted, and returns it with how many attempts it took
+func (mps *MMTPumpSession) fetchPage(cmt CarelinkMessageType, params []byte) ([]byte, int, error) {
+	var err error
+	for attempt := 1; attempt <= mmtHistoryPageAttempts; attempt++ {
+		var data []byte
+		data, err = mps.readFrames(cmt, params, true)
+		if err == nil && len(data) != mmtHistoryPageLength {
+			err = fmt.Errorf("page is %d bytes, expected %d", len(data), mmtHistoryPageLength)
+		}
+		if err == nil {
+			err = checkPageCRC(data)
+		}
+		if err == nil {
+			return data, attempt, nil
+		} else if _, refused := err.(*MMTPumpError); refused {
+			// the pump won't send it however often it's asked
+			return nil, attempt, err
+		}
+		log.WithFields(log.Fields{
+			"command": cmt,
+			"params":  params,
+			"attempt": attempt,
+			"err":     err,
+		}).Debug("fetchPage")
+	}
+	return nil, mmtHistoryPageAttempts, err
+}
+
+// GetHistoryPage fetches a page of history (0 is the most recent),
+// fetching it again if it arrives corrupted
+func (mps *MMTPumpSession) GetHistoryPage(number int) (*MMTHistoryPage, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	}
+	data, attempts, err := mps.fetchPage(CMTGetHistoryPage, []byte{byte(number)})
+	if _, refused := err.(*MMTPumpError); refused {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("history page %d: %v", number, err)
+	}
+	return &MMTHistoryPage{
+		Number:      number,
+		Data:        data,
+		PumpID:      hex.EncodeToString(mps.pumpID),
+		ModelNumber: mps.Pump.ModelNumber,
+		Fetched:     time.Now(),
+		Attempts:    attempts,
+	}, nil
+}
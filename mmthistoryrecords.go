@@ -0,0 +1,499 @@
+// mmthistoryrecords.go contains decoding pump history pages into events
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// MMTHistoryRecordType is the opcode that starts every history record
+type MMTHistoryRecordType byte
+
+const (
+	MMTRecordBolusNormal                  MMTHistoryRecordType = 0x01
+	MMTRecordPrime                        MMTHistoryRecordType = 0x03
+	MMTRecordAlarm                        MMTHistoryRecordType = 0x06
+	MMTRecordResultDailyTotal             MMTHistoryRecordType = 0x07
+	MMTRecordChangeBasalProfilePattern    MMTHistoryRecordType = 0x08
+	MMTRecordChangeBasalProfile           MMTHistoryRecordType = 0x09
+	MMTRecordCalBGForPH                   MMTHistoryRecordType = 0x0a
+	MMTRecordAlarmSensor                  MMTHistoryRecordType = 0x0b
+	MMTRecordClearAlarm                   MMTHistoryRecordType = 0x0c
+	MMTRecordSelectBasalProfile           MMTHistoryRecordType = 0x14
+	MMTRecordTempBasalDuration            MMTHistoryRecordType = 0x16
+	MMTRecordChangeTime                   MMTHistoryRecordType = 0x17
+	MMTRecordNewTime                      MMTHistoryRecordType = 0x18
+	MMTRecordJournalEntryPumpLowBattery   MMTHistoryRecordType = 0x19
+	MMTRecordBattery                      MMTHistoryRecordType = 0x1a
+	MMTRecordSetAutoOff                   MMTHistoryRecordType = 0x1b
+	MMTRecordSuspend                      MMTHistoryRecordType = 0x1e
+	MMTRecordResume                       MMTHistoryRecordType = 0x1f
+	MMTRecordSelftest                     MMTHistoryRecordType = 0x20
+	MMTRecordRewind                       MMTHistoryRecordType = 0x21
+	MMTRecordClearSettings                MMTHistoryRecordType = 0x22
+	MMTRecordChangeChildBlockEnable       MMTHistoryRecordType = 0x23
+	MMTRecordChangeMaxBolus               MMTHistoryRecordType = 0x24
+	MMTRecordEnableDisableRemote          MMTHistoryRecordType = 0x26
+	MMTRecordChangeRemoteID               MMTHistoryRecordType = 0x27
+	MMTRecordChangeMaxBasal               MMTHistoryRecordType = 0x2c
+	MMTRecordChangeBolusWizardEnabled     MMTHistoryRecordType = 0x2d
+	MMTRecordChangeBGReminderOffset       MMTHistoryRecordType = 0x31
+	MMTRecordChangeAlarmClockTime         MMTHistoryRecordType = 0x32
+	MMTRecordTempBasal                    MMTHistoryRecordType = 0x33
+	MMTRecordJournalEntryPumpLowReservoir MMTHistoryRecordType = 0x34
+	MMTRecordAlarmClockReminder           MMTHistoryRecordType = 0x35
+	MMTRecordChangeMeterID                MMTHistoryRecordType = 0x36
+	MMTRecordChangeParadigmLinkID         MMTHistoryRecordType = 0x3b
+	MMTRecordBGReceived                   MMTHistoryRecordType = 0x3f
+	MMTRecordJournalEntryMealMarker       MMTHistoryRecordType = 0x40
+	MMTRecordJournalEntryExerciseMarker   MMTHistoryRecordType = 0x41
+	MMTRecordJournalEntryInsulinMarker    MMTHistoryRecordType = 0x42
+	MMTRecordJournalEntryOtherMarker      MMTHistoryRecordType = 0x43
+	MMTRecordChangeSensorSetup2           MMTHistoryRecordType = 0x50
+	MMTRecordChangeSensorRateOfChange     MMTHistoryRecordType = 0x56
+	MMTRecordChangeBolusScrollStepSize    MMTHistoryRecordType = 0x57
+	MMTRecordChangeBolusWizardSetup       MMTHistoryRecordType = 0x5a
+	MMTRecordBolusWizard                  MMTHistoryRecordType = 0x5b
+	MMTRecordUnabsorbedInsulin            MMTHistoryRecordType = 0x5c
+	MMTRecordSaveSettings                 MMTHistoryRecordType = 0x5d
+	MMTRecordChangeVariableBolus          MMTHistoryRecordType = 0x5e
+	MMTRecordChangeAudioBolus             MMTHistoryRecordType = 0x5f
+	MMTRecordChangeBGReminderEnable       MMTHistoryRecordType = 0x60
+	MMTRecordChangeAlarmClockEnable       MMTHistoryRecordType = 0x61
+	MMTRecordChangeTempBasalType          MMTHistoryRecordType = 0x62
+	MMTRecordChangeAlarmNotifyMode        MMTHistoryRecordType = 0x63
+	MMTRecordChangeTimeFormat             MMTHistoryRecordType = 0x64
+	MMTRecordChangeReservoirWarningTime   MMTHistoryRecordType = 0x65
+	MMTRecordChangeBolusReminderEnable    MMTHistoryRecordType = 0x66
+	MMTRecordChangeBolusReminderTime      MMTHistoryRecordType = 0x67
+	MMTRecordDeleteBolusReminderTime      MMTHistoryRecordType = 0x68
+	MMTRecordBolusReminder                MMTHistoryRecordType = 0x69
+	MMTRecordDeleteAlarmClockTime         MMTHistoryRecordType = 0x6a
+	MMTRecordDailyTotal515                MMTHistoryRecordType = 0x6c
+	MMTRecordDailyTotal522                MMTHistoryRecordType = 0x6d
+	MMTRecordDailyTotal523                MMTHistoryRecordType = 0x6e
+	MMTRecordChangeCarbUnits              MMTHistoryRecordType = 0x6f
+	MMTRecordBasalProfileStart            MMTHistoryRecordType = 0x7b
+	MMTRecordChangeWatchdogEnable         MMTHistoryRecordType = 0x7c
+	MMTRecordChangeOtherDeviceID          MMTHistoryRecordType = 0x7d
+	MMTRecordChangeWatchdogMarriage       MMTHistoryRecordType = 0x81
+	MMTRecordDeleteOtherDeviceID          MMTHistoryRecordType = 0x82
+	MMTRecordChangeCaptureEventEnable     MMTHistoryRecordType = 0x83
+	// MMTRecordUnknown marks the rest of a page from an opcode that isn't
+	// known, since without its length the next record can't be found
+	MMTRecordUnknown MMTHistoryRecordType = 0xff
+)
+
+// MMTHistoryEvent is any decoded history record
+type MMTHistoryEvent interface {
+	Record() *MMTHistoryRecord
+}
+
+// MMTHistoryRecord is the part common to all records, and is itself the
+// event for records that carry nothing but their time
+type MMTHistoryRecord struct {
+	Type MMTHistoryRecordType
+	// Timestamp is zero for records without one (or with a corrupt one)
+	Timestamp time.Time
+	// Data is the whole raw record, opcode included
+	Data []byte
+}
+
+// Record returns the common part of the event
+func (mhr *MMTHistoryRecord) Record() *MMTHistoryRecord {
+	return mhr
+}
+
+// MMTBolusEvent is a bolus (0x01); Duration is zero for normal boluses
+type MMTBolusEvent struct {
+	MMTHistoryRecord
+	// Programmed, Amount and Unabsorbed are in U
+	Programmed float64
+	Amount     float64
+	Unabsorbed float64
+	Duration   time.Duration
+	// AtStart is set for square wave boluses on pumps that record them
+	// when delivery starts, so Amount hasn't necessarily been delivered
+	AtStart bool
+}
+
+// MMTBolusWizardEvent is the bolus wizard's calculation (0x5b)
+type MMTBolusWizardEvent struct {
+	MMTHistoryRecord
+	// Carbs is in grams (or exchanges, per the pump's carb units)
+	Carbs int
+	// BG, InsulinSensitivity and the targets are in mg/dL (or mmol/L x10)
+	BG                 int
+	CarbRatio          float64
+	InsulinSensitivity int
+	BGTargetLow        int
+	BGTargetHigh       int
+	// the estimates are in U
+	FoodEstimate       float64
+	CorrectionEstimate float64
+	UnabsorbedInsulin  float64
+	BolusEstimate      float64
+}
+
+// MMTTempBasalEvent is a temp basal rate being set (0x33); it is always
+// followed by an MMTTempBasalDurationEvent
+type MMTTempBasalEvent struct {
+	MMTHistoryRecord
+	RateType MMTTempBasalType
+	// Rate is in U/h for absolute temp basals
+	Rate    float64
+	Percent int
+}
+
+// MMTTempBasalDurationEvent is the duration of a temp basal (0x16)
+type MMTTempBasalDurationEvent struct {
+	MMTHistoryRecord
+	Duration time.Duration
+}
+
+// MMTBasalProfileStartEvent is a scheduled basal rate starting (0x7b)
+type MMTBasalProfileStartEvent struct {
+	MMTHistoryRecord
+	Profile MMTBasalProfile
+	// Start is the scheduled time of day of the segment
+	Start time.Duration
+	// Rate is in U/h
+	Rate float64
+}
+
+// MMTPrimeEvent is the cannula or tubing being primed (0x03)
+type MMTPrimeEvent struct {
+	MMTHistoryRecord
+	// Fixed is set for a fixed (cannula) prime, manual otherwise
+	Fixed bool
+	// Programmed and Amount are in U
+	Programmed float64
+	Amount     float64
+}
+
+// MMTAlarmEvent is a pump alarm (0x06)
+type MMTAlarmEvent struct {
+	MMTHistoryRecord
+	AlarmType MMTAlarmCode
+}
+
+// MMTBGEvent is a BG entered (0x0a) or received from a meter (0x3f)
+type MMTBGEvent struct {
+	MMTHistoryRecord
+	// BG is in mg/dL
+	BG int
+	// MeterID is set if the BG came from a linked meter
+	MeterID string
+}
+
+// MMTJournalEntryEvent is a marker entered by the user (0x40-0x43)
+type MMTJournalEntryEvent struct {
+	MMTHistoryRecord
+	// Carbs is set on meal markers
+	Carbs int
+}
+
+// MMTDailyTotalEvent is the pump's summary of a day (0x07, 0x6c-0x6e),
+// written just after midnight; Timestamp is the day summarized
+type MMTDailyTotalEvent struct {
+	MMTHistoryRecord
+	// Total, Basal and Bolus are the day's insulin in U, as the pump
+	// counted it; MMTRecordResultDailyTotal only has the Total
+	Total float64
+	Basal float64
+	Bolus float64
+	// Carbs is what was entered, in the pump's carb units
+	Carbs int
+	// BGCount and BGAverage (mg/dL) cover the BGs entered or received
+	BGCount   int
+	BGAverage int
+}
+
+// dailyTotalLayout is where the fields of the 0x6c-0x6e summaries are,
+// counted from the end of their date; the insulin fields are two bytes
+// of 1/40 U
+type dailyTotalLayout struct {
+	bgAverage, bgCount, carbs, total, basal, bolus int
+}
+
+var dailyTotalLayouts = map[MMTHistoryRecordType]dailyTotalLayout{
+	MMTRecordDailyTotal515: {bgAverage: 0, bgCount: 4, carbs: 6, total: 8, basal: 12, bolus: 16},
+	MMTRecordDailyTotal522: {bgAverage: 0, bgCount: 4, carbs: 6, total: 8, basal: 12, bolus: 16},
+	MMTRecordDailyTotal523: {bgAverage: 0, bgCount: 4, carbs: 6, total: 8, basal: 10, bolus: 12},
+}
+
+// decodeDailyTotal unpacks a 0x6c-0x6e summary
+func decodeDailyTotal(record MMTHistoryRecord, data []byte) *MMTDailyTotalEvent {
+	layout := dailyTotalLayouts[record.Type]
+	body := data[3:]
+	word := func(i int) int {
+		return int(body[i])<<8 | int(body[i+1])
+	}
+	return &MMTDailyTotalEvent{
+		MMTHistoryRecord: record,
+		Total:            float64(word(layout.total)) / 40,
+		Basal:            float64(word(layout.basal)) / 40,
+		Bolus:            float64(word(layout.bolus)) / 40,
+		Carbs:            word(layout.carbs),
+		BGCount:          int(body[layout.bgCount]),
+		BGAverage:        word(layout.bgAverage),
+	}
+}
+
+// DecodeHistoryPage turns the records of a history page (without its CRC)
+// into events.  Records are laid out according to the pump model, and
+// timestamps are taken to be in loc.  Decoding stops at an opcode that
+// isn't known, keeping the rest of the page, less its zero padding, as a
+// single MMTRecordUnknown event
+func DecodeHistoryPage(records []byte, pump *MedtronicPump, loc *time.Location) []MMTHistoryEvent {
+	var events []MMTHistoryEvent
+	for i := 0; i < len(records); {
+		// pages are zero-padded after the last record
+		if records[i] == 0x00 {
+			i++
+			continue
+		}
+		length, ok := historyRecordLength(MMTHistoryRecordType(records[i]), records[i:], pump)
+		if !ok || i+length > len(records) {
+			end := len(records)
+			for end > i+1 && records[end-1] == 0x00 {
+				end--
+			}
+			events = append(events, &MMTHistoryRecord{MMTRecordUnknown, time.Time{}, records[i:end]})
+			break
+		}
+		events = append(events, decodeHistoryRecord(records[i:i+length], pump, loc))
+		i += length
+	}
+	return events
+}
+
+// Events decodes the page in the time zone the pump clock is kept in
+func (mhp *MMTHistoryPage) Events(loc *time.Location) []MMTHistoryEvent {
+	return DecodeHistoryPage(mhp.Records(), &MedtronicPump{mhp.ModelNumber}, loc)
+}
+
+// historyRecordLength returns how long a record of this type is on this
+// pump, or false if the type isn't known
+func historyRecordLength(rt MMTHistoryRecordType, data []byte, pump *MedtronicPump) (int, bool) {
+	switch rt {
+	case MMTRecordBolusNormal:
+		if pump.NewRecordStyle() {
+			return 13, true
+		}
+		return 9, true
+	case MMTRecordPrime, MMTRecordBGReceived, MMTRecordBasalProfileStart:
+		return 10, true
+	case MMTRecordAlarm, MMTRecordJournalEntryMealMarker, MMTRecordChangeBolusReminderTime,
+		MMTRecordDeleteBolusReminderTime, MMTRecordBolusReminder:
+		return 9, true
+	case MMTRecordResultDailyTotal:
+		if pump.NewRecordStyle() {
+			return 10, true
+		}
+		return 7, true
+	case MMTRecordChangeBasalProfilePattern, MMTRecordChangeBasalProfile:
+		return 152, true
+	case MMTRecordAlarmSensor, MMTRecordTempBasal, MMTRecordJournalEntryExerciseMarker,
+		MMTRecordJournalEntryInsulinMarker:
+		return 8, true
+	case MMTRecordCalBGForPH, MMTRecordClearAlarm, MMTRecordSelectBasalProfile,
+		MMTRecordTempBasalDuration, MMTRecordChangeTime, MMTRecordNewTime,
+		MMTRecordJournalEntryPumpLowBattery, MMTRecordBattery, MMTRecordSetAutoOff,
+		MMTRecordSuspend, MMTRecordResume, MMTRecordSelftest, MMTRecordRewind,
+		MMTRecordClearSettings, MMTRecordChangeChildBlockEnable, MMTRecordChangeMaxBolus,
+		MMTRecordChangeRemoteID, MMTRecordChangeMaxBasal, MMTRecordChangeBolusWizardEnabled,
+		MMTRecordChangeBGReminderOffset, MMTRecordJournalEntryPumpLowReservoir,
+		MMTRecordAlarmClockReminder, MMTRecordJournalEntryOtherMarker,
+		MMTRecordChangeBolusScrollStepSize, MMTRecordSaveSettings, MMTRecordChangeVariableBolus,
+		MMTRecordChangeAudioBolus, MMTRecordChangeBGReminderEnable,
+		MMTRecordChangeAlarmClockEnable, MMTRecordChangeTempBasalType,
+		MMTRecordChangeAlarmNotifyMode, MMTRecordChangeTimeFormat,
+		MMTRecordChangeReservoirWarningTime, MMTRecordChangeBolusReminderEnable,
+		MMTRecordChangeCarbUnits, MMTRecordChangeWatchdogEnable,
+		MMTRecordChangeCaptureEventEnable:
+		return 7, true
+	case MMTRecordEnableDisableRemote, MMTRecordChangeMeterID, MMTRecordChangeParadigmLinkID:
+		return 21, true
+	case MMTRecordChangeSensorSetup2:
+		if pump.HasLowSuspend() {
+			return 41, true
+		}
+		return 37, true
+	case MMTRecordChangeSensorRateOfChange, MMTRecordChangeWatchdogMarriage,
+		MMTRecordDeleteOtherDeviceID:
+		return 12, true
+	case MMTRecordChangeBolusWizardSetup:
+		if pump.NewRecordStyle() {
+			return 144, true
+		}
+		return 124, true
+	case MMTRecordBolusWizard:
+		if pump.NewRecordStyle() {
+			return 22, true
+		}
+		return 20, true
+	case MMTRecordUnabsorbedInsulin:
+		// the second byte is the length of the whole record
+		if len(data) < 2 || data[1] < 2 {
+			return 2, true
+		}
+		return int(data[1]), true
+	case MMTRecordChangeAlarmClockTime, MMTRecordDeleteAlarmClockTime:
+		return 14, true
+	case MMTRecordDailyTotal515:
+		return 38, true
+	case MMTRecordDailyTotal522:
+		return 44, true
+	case MMTRecordDailyTotal523:
+		return 52, true
+	case MMTRecordChangeOtherDeviceID:
+		return 37, true
+	default:
+		return 0, false
+	}
+}
+
+// decodeHistoryTimestamp unpacks the 5-byte timestamp found in most records:
+// seconds, minutes, hours, day and year in the low bits of each byte,
+// with the month split across the top bits of the first two
+func decodeHistoryTimestamp(data []byte, loc *time.Location) time.Time {
+	if len(data) < 5 {
+		return time.Time{}
+	}
+	second := int(data[0] & 0x3f)
+	minute := int(data[1] & 0x3f)
+	hour := int(data[2] & 0x1f)
+	day := int(data[3] & 0x1f)
+	month := int(data[0]>>4&0x0c | data[1]>>6)
+	year := 2000 + int(data[4]&0x7f)
+	if month < 1 || month > 12 || day < 1 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+}
+
+// decodeHistoryDate unpacks the 2-byte date-only timestamp of the daily
+// summary records
+func decodeHistoryDate(data []byte, loc *time.Location) time.Time {
+	if len(data) < 2 {
+		return time.Time{}
+	}
+	day := int(data[0] & 0x1f)
+	month := int(data[0]>>4&0x0e | data[1]>>7)
+	year := 2000 + int(data[1]&0x7f)
+	if month < 1 || month > 12 || day < 1 {
+		return time.Time{}
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+}
+
+// decodeHistoryRecord decodes a single record of known type and length
+func decodeHistoryRecord(data []byte, pump *MedtronicPump, loc *time.Location) MMTHistoryEvent {
+	record := MMTHistoryRecord{
+		Type:      MMTHistoryRecordType(data[0]),
+		Timestamp: decodeHistoryTimestamp(data[2:], loc),
+		Data:      data,
+	}
+	switch record.Type {
+	case MMTRecordBolusNormal:
+		event := &MMTBolusEvent{MMTHistoryRecord: record}
+		if pump.NewRecordStyle() {
+			event.Programmed = float64(int(data[1])<<8|int(data[2])) / 40
+			event.Amount = float64(int(data[3])<<8|int(data[4])) / 40
+			event.Unabsorbed = float64(int(data[5])<<8|int(data[6])) / 40
+			event.Duration = time.Duration(data[7]) * 30 * time.Minute
+			event.Timestamp = decodeHistoryTimestamp(data[8:], loc)
+		} else {
+			event.Programmed = float64(data[1]) / 10
+			event.Amount = float64(data[2]) / 10
+			event.Duration = time.Duration(data[3]) * 30 * time.Minute
+			event.Timestamp = decodeHistoryTimestamp(data[4:], loc)
+		}
+		event.AtStart = event.Duration > 0 && pump.ASWTHOSOD()
+		return event
+	case MMTRecordPrime:
+		return &MMTPrimeEvent{
+			MMTHistoryRecord: MMTHistoryRecord{record.Type, decodeHistoryTimestamp(data[5:], loc), data},
+			Fixed:            data[2] != 0,
+			Programmed:       float64(data[2]) / 10,
+			Amount:           float64(data[4]) / 10,
+		}
+	case MMTRecordAlarm:
+		record.Timestamp = decodeHistoryTimestamp(data[4:], loc)
+		return &MMTAlarmEvent{record, MMTAlarmCode(data[1])}
+	case MMTRecordResultDailyTotal:
+		record.Timestamp = decodeHistoryDate(data[5:], loc)
+		return &MMTDailyTotalEvent{
+			MMTHistoryRecord: record,
+			Total:            float64(int(data[3])<<8|int(data[4])) / 40,
+		}
+	case MMTRecordDailyTotal515, MMTRecordDailyTotal522, MMTRecordDailyTotal523:
+		record.Timestamp = decodeHistoryDate(data[1:], loc)
+		return decodeDailyTotal(record, data)
+	case MMTRecordUnabsorbedInsulin:
+		record.Timestamp = time.Time{}
+		return &record
+	case MMTRecordCalBGForPH:
+		return &MMTBGEvent{MMTHistoryRecord: record, BG: int(data[6]&0x80)<<1 | int(data[1])}
+	case MMTRecordBGReceived:
+		return &MMTBGEvent{
+			record,
+			int(data[1])<<3 | int(data[4]>>5),
+			hex.EncodeToString(data[7:10]),
+		}
+	case MMTRecordTempBasal:
+		event := &MMTTempBasalEvent{MMTHistoryRecord: record, RateType: MMTTempBasalType(data[7] >> 3)}
+		if event.RateType == MMTTempBasalPercent {
+			event.Percent = int(data[1])
+		} else {
+			event.Rate = float64(int(data[7]&0x07)<<8|int(data[1])) / 40
+		}
+		return event
+	case MMTRecordTempBasalDuration:
+		return &MMTTempBasalDurationEvent{record, time.Duration(data[1]) * 30 * time.Minute}
+	case MMTRecordBasalProfileStart:
+		if !pump.RBPSE() {
+			return &record
+		}
+		return &MMTBasalProfileStartEvent{
+			record,
+			MMTBasalProfile(data[1]),
+			time.Duration(data[7]) * 30 * time.Minute,
+			float64(int(data[8])|int(data[9])<<8) / 40,
+		}
+	case MMTRecordBolusWizard:
+		event := &MMTBolusWizardEvent{MMTHistoryRecord: record}
+		event.BG = int(data[8]&0x03)<<8 | int(data[1])
+		if pump.NewRecordStyle() {
+			event.Carbs = int(data[8]&0x0c)<<6 | int(data[7])
+			event.CarbRatio = float64(int(data[9]&0x07)<<8|int(data[10])) / 10
+			event.InsulinSensitivity = int(data[11])
+			event.BGTargetLow = int(data[12])
+			event.CorrectionEstimate = float64(int(data[16]&0x38)<<5|int(data[13])) / 40
+			event.FoodEstimate = float64(int(data[14])<<8|int(data[15])) / 40
+			event.UnabsorbedInsulin = float64(int(data[17])<<8|int(data[18])) / 40
+			event.BolusEstimate = float64(int(data[19])<<8|int(data[20])) / 40
+			event.BGTargetHigh = int(data[21])
+		} else {
+			event.Carbs = int(data[7])
+			event.CarbRatio = float64(data[9])
+			event.InsulinSensitivity = int(data[10])
+			event.BGTargetLow = int(data[11])
+			event.CorrectionEstimate = float64(int(data[14])<<8|int(data[12])) / 10
+			event.FoodEstimate = float64(data[13]) / 10
+			event.UnabsorbedInsulin = float64(data[16]) / 10
+			event.BolusEstimate = float64(data[18]) / 10
+			event.BGTargetHigh = int(data[19])
+		}
+		return event
+	case MMTRecordJournalEntryMealMarker:
+		return &MMTJournalEntryEvent{record, int(data[8]&0x01)<<8 | int(data[7])}
+	case MMTRecordJournalEntryExerciseMarker, MMTRecordJournalEntryInsulinMarker,
+		MMTRecordJournalEntryOtherMarker:
+		return &MMTJournalEntryEvent{MMTHistoryRecord: record}
+	default:
+		return &record
+	}
+}
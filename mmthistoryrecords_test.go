@@ -0,0 +1,92 @@
+// mmthistoryrecords_test.go contains tests of decoding history pages into
+// events
+
+package gorileylink
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// a x23 and newer history page from 2016-02-21, its records laid out as
+// MinimedKit's PumpEvent types read them: a 3.2 U bolus with 0.9 U
+// unabsorbed, a 1.5 U/h temp basal for an hour, settings saved, the BG
+// reminder offset and an alarm clock time changed, then an opcode that
+// isn't known and the page's zero padding
+const historyPageModern = "010080008000240009a24a1510" +
+	"333c00a80a151000" +
+	"160200a80a1510" +
+	"5d0000ad0a1510" +
+	"310000ae0a1510" +
+	"320000af0a151000000000000000" +
+	"99333c01" +
+	"0000000000000000"
+
+func TestDecodeHistoryPage(t *testing.T) {
+	events := DecodeHistoryPage(mustDecodeHex(t, historyPageModern), &MedtronicPump{ModelNumber: 554}, time.UTC)
+	want := []MMTHistoryRecordType{
+		MMTRecordBolusNormal, MMTRecordTempBasal, MMTRecordTempBasalDuration,
+		MMTRecordSaveSettings, MMTRecordChangeBGReminderOffset, MMTRecordChangeAlarmClockTime,
+		MMTRecordUnknown,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("decoded %d events: %v", len(events), events)
+	}
+	for i, rt := range want {
+		if got := events[i].Record().Type; got != rt {
+			t.Errorf("event %d is %v, want %v", i, got, rt)
+		}
+	}
+
+	bolus, ok := events[0].(*MMTBolusEvent)
+	if !ok {
+		t.Fatalf("bolus decoded as %T", events[0])
+	} else if bolus.Programmed != 3.2 || bolus.Amount != 3.2 || bolus.Unabsorbed != 0.9 || bolus.Duration != 0 {
+		t.Errorf("bolus %+v", bolus)
+	} else if want := time.Date(2016, 2, 21, 10, 34, 9, 0, time.UTC); !bolus.Timestamp.Equal(want) {
+		t.Errorf("bolus at %v", bolus.Timestamp)
+	}
+	if temp, ok := events[1].(*MMTTempBasalEvent); !ok || temp.RateType != MMTTempBasalAbsolute || temp.Rate != 1.5 {
+		t.Errorf("temp basal %+v", events[1])
+	}
+	if duration, ok := events[2].(*MMTTempBasalDurationEvent); !ok || duration.Duration != time.Hour {
+		t.Errorf("temp basal duration %+v", events[2])
+	}
+	for i, minute := range []int{45, 46, 47} {
+		if want := time.Date(2016, 2, 21, 10, minute, 0, 0, time.UTC); !events[3+i].Record().Timestamp.Equal(want) {
+			t.Errorf("%v at %v", events[3+i].Record().Type, events[3+i].Record().Timestamp)
+		}
+	}
+	// nothing after the unknown opcode is decoded, even what looks like a
+	// record; the padding is left off
+	if unknown := events[6].Record(); !bytes.Equal(unknown.Data, []byte{0x99, 0x33, 0x3c, 0x01}) {
+		t.Errorf("unknown %x", unknown.Data)
+	}
+}
+
+func TestDecodeHistoryPageLegacy(t *testing.T) {
+	// before the x23, boluses are 9 bytes of 0.1 U strokes
+	page := mustDecodeHex(t, "0120200009a24a1510"+"160200a80a1510"+"000000")
+	events := DecodeHistoryPage(page, &MedtronicPump{ModelNumber: 522}, time.UTC)
+	if len(events) != 2 {
+		t.Fatalf("decoded %d events: %v", len(events), events)
+	}
+	if bolus, ok := events[0].(*MMTBolusEvent); !ok || bolus.Programmed != 3.2 || bolus.Amount != 3.2 {
+		t.Errorf("bolus %+v", events[0])
+	}
+	if events[1].Record().Type != MMTRecordTempBasalDuration {
+		t.Errorf("second event %v", events[1].Record().Type)
+	}
+}
+
+func TestDecodeHistoryPageTruncated(t *testing.T) {
+	// a temp basal cut short by the end of the page
+	events := DecodeHistoryPage(mustDecodeHex(t, "160200a80a1510"+"333c00a8"), &MedtronicPump{ModelNumber: 554}, time.UTC)
+	if len(events) != 2 {
+		t.Fatalf("decoded %d events: %v", len(events), events)
+	}
+	if unknown := events[1].Record(); unknown.Type != MMTRecordUnknown || !bytes.Equal(unknown.Data, []byte{0x33, 0x3c, 0x00, 0xa8}) {
+		t.Errorf("truncated record %v %x", unknown.Type, unknown.Data)
+	}
+}
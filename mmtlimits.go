@@ -0,0 +1,165 @@
+// mmtlimits.go contains the pump's max bolus and max basal rate, and the
+// policy that decides which values may be written to them
+
+package gorileylink
+
+import (
+	"fmt"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MMTLimits is a max bolus (U) and max basal rate (U/h); a zero field is
+// left as it is on the pump
+type MMTLimits struct {
+	MaxBolus float64
+	MaxBasal float64
+}
+
+// MMTLimitsPolicy is the clinical range limits must fall within
+type MMTLimitsPolicy struct {
+	MinMaxBolus float64
+	MaxMaxBolus float64
+	MinMaxBasal float64
+	MaxMaxBasal float64
+}
+
+// Check refuses limits outside the policy or that the pump can't store
+func (mlp *MMTLimitsPolicy) Check(limits MMTLimits, pump *MedtronicPump) error {
+	if limits.MaxBolus != 0 {
+		if limits.MaxBolus < mlp.MinMaxBolus || limits.MaxBolus > mlp.MaxMaxBolus {
+			return fmt.Errorf("max bolus %v U is outside the policy's %v-%v U", limits.MaxBolus, mlp.MinMaxBolus, mlp.MaxMaxBolus)
+		}
+		_, err := encodeMaxBolus(limits.MaxBolus, pump)
+		if err != nil {
+			return err
+		}
+	}
+	if limits.MaxBasal != 0 {
+		if limits.MaxBasal < mlp.MinMaxBasal || limits.MaxBasal > mlp.MaxMaxBasal {
+			return fmt.Errorf("max basal %v U/h is outside the policy's %v-%v U/h", limits.MaxBasal, mlp.MinMaxBasal, mlp.MaxMaxBasal)
+		}
+		_, err := encodeMaxBasal(limits.MaxBasal, pump)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMaxBolus packs a max bolus as a byte of 1/10 U, within what the
+// pump's model can be set to
+func encodeMaxBolus(units float64, pump *MedtronicPump) ([]byte, error) {
+	caps, ok := pump.Capabilities()
+	if !ok {
+		return nil, fmt.Errorf("unknown pump model: %d", pump.ModelNumber)
+	}
+	ticks := units * mmtMaxBolusMultiplier
+	if units < 0 || units > caps.MaxBolus {
+		return nil, fmt.Errorf("max bolus %v U is outside 0-%v U", units, caps.MaxBolus)
+	} else if math.Abs(ticks-math.Round(ticks)) > 1e-6 {
+		return nil, fmt.Errorf("max bolus %v U is not a multiple of %v U", units, 1.0/mmtMaxBolusMultiplier)
+	}
+	return []byte{byte(math.Round(ticks))}, nil
+}
+
+// encodeMaxBasal packs a max basal rate as two bytes of 1/40 U/h, within
+// what the pump's model can be set to and in its basal increment
+func encodeMaxBasal(rate float64, pump *MedtronicPump) ([]byte, error) {
+	caps, ok := pump.Capabilities()
+	if !ok {
+		return nil, fmt.Errorf("unknown pump model: %d", pump.ModelNumber)
+	}
+	steps := rate / caps.BasalIncrement
+	if rate < 0 || rate > caps.MaxBasalRate {
+		return nil, fmt.Errorf("max basal %v U/h is outside 0-%v U/h", rate, caps.MaxBasalRate)
+	} else if math.Abs(steps-math.Round(steps)) > 1e-6 {
+		return nil, fmt.Errorf("max basal %v U/h is not a multiple of %v U/h", rate, caps.BasalIncrement)
+	}
+	strokes := int(math.Round(rate * mmtMaxBasalMultiplier))
+	return []byte{byte(strokes >> 8), byte(strokes)}, nil
+}
+
+// setMaxBolus sets the pump's max bolus and reads it back; it is only
+// reached through ApplyLimits, so never without a policy
+func (mps *MMTPumpSession) setMaxBolus(units float64) error {
+	params, err := encodeMaxBolus(units, mps.Pump)
+	if err != nil {
+		return err
+	}
+	err = mps.setCommand(CMTSetMaxBolus, params)
+	if err != nil {
+		return err
+	}
+	settings, err := mps.ReadSettings()
+	if err != nil {
+		return err
+	} else if math.Abs(settings.MaxBolus-units) > 1e-6 {
+		return fmt.Errorf("max bolus reads back as %v U, not %v U", settings.MaxBolus, units)
+	}
+	return nil
+}
+
+// setMaxBasalRate sets the pump's max basal rate and reads it back; it is
+// only reached through ApplyLimits, so never without a policy
+func (mps *MMTPumpSession) setMaxBasalRate(rate float64) error {
+	params, err := encodeMaxBasal(rate, mps.Pump)
+	if err != nil {
+		return err
+	}
+	err = mps.setCommand(CMTSetMaxBasalRate, params)
+	if err != nil {
+		return err
+	}
+	settings, err := mps.ReadSettings()
+	if err != nil {
+		return err
+	} else if math.Abs(settings.MaxBasal-rate) > 1e-6 {
+		return fmt.Errorf("max basal reads back as %v U/h, not %v U/h", settings.MaxBasal, rate)
+	}
+	return nil
+}
+
+// ApplyLimits brings the pump to the given limits, writing only what
+// differs, after checking them against the policy.  Lowering the max
+// basal below the pump's basal schedules or temp basal is left to the
+// pump to refuse
+func (mps *MMTPumpSession) ApplyLimits(policy *MMTLimitsPolicy, limits MMTLimits) error {
+	if policy == nil {
+		return fmt.Errorf("limits refused: no policy")
+	}
+	err := mps.ensureModel()
+	if err != nil {
+		return err
+	}
+	err = policy.Check(limits, mps.Pump)
+	if err != nil {
+		return fmt.Errorf("limits refused: %v", err)
+	}
+	settings, err := mps.ReadSettings()
+	if err != nil {
+		return err
+	}
+	if limits.MaxBolus != 0 && math.Abs(settings.MaxBolus-limits.MaxBolus) > 1e-6 {
+		log.WithFields(log.Fields{
+			"from": settings.MaxBolus,
+			"to":   limits.MaxBolus,
+		}).Info("setting max bolus")
+		err = mps.setMaxBolus(limits.MaxBolus)
+		if err != nil {
+			return err
+		}
+	}
+	if limits.MaxBasal != 0 && math.Abs(settings.MaxBasal-limits.MaxBasal) > 1e-6 {
+		log.WithFields(log.Fields{
+			"from": settings.MaxBasal,
+			"to":   limits.MaxBasal,
+		}).Info("setting max basal")
+		err = mps.setMaxBasalRate(limits.MaxBasal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
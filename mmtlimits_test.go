@@ -0,0 +1,67 @@
+// mmtlimits_test.go contains tests of encoding and checking pump limits
+
+package gorileylink
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeLimits(t *testing.T) {
+	for _, model := range []int{522, 554} {
+		pump := &MedtronicPump{ModelNumber: model}
+		// as they appear in the captured settings reply
+		params, err := encodeMaxBolus(15, pump)
+		if err != nil || !bytes.Equal(params, []byte{0x96}) {
+			t.Errorf("%d: max bolus 15 U encoded as %x (%v)", model, params, err)
+		}
+		params, err = encodeMaxBasal(3.5, pump)
+		if err != nil || !bytes.Equal(params, []byte{0x00, 0x8c}) {
+			t.Errorf("%d: max basal 3.5 U/h encoded as %x (%v)", model, params, err)
+		}
+		if _, err = encodeMaxBolus(25.1, pump); err == nil {
+			t.Errorf("%d: max bolus over the model's limit encoded", model)
+		}
+		if _, err = encodeMaxBolus(2.25, pump); err == nil {
+			t.Errorf("%d: max bolus off the 0.1 U grid encoded", model)
+		}
+		if _, err = encodeMaxBasal(35.05, pump); err == nil {
+			t.Errorf("%d: max basal over the model's limit encoded", model)
+		}
+	}
+	// only x23 and newer pumps take basal rates in 0.025 U/h
+	if _, err := encodeMaxBasal(1.025, &MedtronicPump{ModelNumber: 522}); err == nil {
+		t.Error("522: max basal of 1.025 U/h encoded")
+	}
+	if _, err := encodeMaxBasal(1.025, &MedtronicPump{ModelNumber: 554}); err != nil {
+		t.Errorf("554: max basal of 1.025 U/h refused: %v", err)
+	}
+	if _, err := encodeMaxBolus(10, &MedtronicPump{ModelNumber: 599}); err == nil {
+		t.Error("unknown model: max bolus encoded")
+	}
+}
+
+func TestLimitsPolicyCheck(t *testing.T) {
+	policy := &MMTLimitsPolicy{MinMaxBolus: 1, MaxMaxBolus: 10, MinMaxBasal: 0.5, MaxMaxBasal: 3}
+	pump := &MedtronicPump{ModelNumber: 554}
+	for _, limits := range []MMTLimits{
+		{MaxBolus: 10, MaxBasal: 3},
+		{MaxBolus: 5},
+		{MaxBasal: 0.5},
+		{},
+	} {
+		if err := policy.Check(limits, pump); err != nil {
+			t.Errorf("%+v refused: %v", limits, err)
+		}
+	}
+	for _, limits := range []MMTLimits{
+		{MaxBolus: 10.5},
+		{MaxBolus: 0.5},
+		{MaxBasal: 3.025},
+		{MaxBasal: 0.25},
+	} {
+		if policy.Check(limits, pump) == nil {
+			t.Errorf("%+v allowed", limits)
+		}
+	}
+}
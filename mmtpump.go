@@ -1,5 +1,6 @@
 package gorileylink
 
+// MedtronicPump describes a Medtronic (MiniMed/Paradigm) pump by model
 type MedtronicPump struct {
 	ModelNumber int
 }
@@ -12,51 +13,150 @@ const (
 	MMTPumpSizeLarge   MMTPumpSize = 700
 )
 
+// MMTHistoryLayout is the record layout used in the pump's history pages
+type MMTHistoryLayout int
+
+const (
+	MMTHistoryLayoutUnknown MMTHistoryLayout = 0
+	// MMTHistoryLayoutLegacy is used by x22 and older pumps
+	MMTHistoryLayoutLegacy MMTHistoryLayout = 1
+	// MMTHistoryLayoutModern is used by x23 and newer pumps, with
+	// larger bolus and wizard records for the finer stroke precision
+	MMTHistoryLayoutModern MMTHistoryLayout = 2
+)
+
+func (mhl MMTHistoryLayout) String() string {
+	switch mhl {
+	case MMTHistoryLayoutLegacy:
+		return "MMTHistoryLayoutLegacy"
+	case MMTHistoryLayoutModern:
+		return "MMTHistoryLayoutModern"
+	default:
+		return "MMTHistoryLayoutUNKNOWN"
+	}
+}
+
+// MMTPumpCapabilities is everything known about a pump model
+type MMTPumpCapabilities struct {
+	ReservoirSize MMTPumpSize
+	// ReservoirUnits is the reservoir capacity in units of insulin
+	ReservoirUnits int
+	Generation     int
+	// BolusIncrement is the smallest bolus step in U
+	BolusIncrement float64
+	// BasalIncrement is the smallest basal rate step in U/h
+	BasalIncrement float64
+	// MaxBasalRate is the highest programmable basal rate in U/h
+	MaxBasalRate float64
+	// MaxBolus is the largest programmable bolus in U
+	MaxBolus float64
+	// StrokesPerUnit is the delivery precision, how many pump strokes
+	// make up a unit of insulin
+	StrokesPerUnit int
+	// BasalStrokesPerUnit is the precision basal rates are stored and
+	// programmed in, which is finer than the bolus precision on older
+	// models
+	BasalStrokesPerUnit int
+	HistoryLayout       MMTHistoryLayout
+	// MySentry is whether the pump broadcasts to a MySentry monitor
+	MySentry bool
+	// BolusErrorQuirk is whether the pump answers a bolus with an error
+	// even though it delivers it
+	BolusErrorQuirk bool
+	// LowSuspend is whether the pump suspends itself on low glucose
+	LowSuspend bool
+	// BasalProfileStartEvents is whether history records every start of
+	// a basal profile segment
+	BasalProfileStartEvents bool
+	// SquareWaveAtStart is whether a square wave bolus is recorded when it
+	// starts rather than when it ends
+	SquareWaveAtStart bool
+	Commands          []CarelinkMessageType
+}
+
+// Supports returns whether the model accepts a given command
+func (caps *MMTPumpCapabilities) Supports(cmt CarelinkMessageType) bool {
+	for _, c := range caps.Commands {
+		if c == cmt {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGeneration returns the generation (the last two digits of the model)
 func (mmtpump *MedtronicPump) GetGeneration() int {
-	return mmtpump.ModelNumber
+	return mmtpump.generation()
 }
 
+// GetMaxReserviorSize returns the reservoir size class of the pump
 func (mmtpump *MedtronicPump) GetMaxReserviorSize() MMTPumpSize {
-	if mmtpump.ModelNumber&int(MMTPumpSizeSmall) == int(MMTPumpSizeSmall) {
-		return MMTPumpSizeSmall
-	} else if mmtpump.ModelNumber&int(MMTPumpSizeLarge) == int(MMTPumpSizeLarge) {
-		return MMTPumpSizeLarge
-	} else {
+	caps, ok := mmtpump.Capabilities()
+	if !ok {
 		return MMTPumpSizeUnknown
 	}
+	return caps.ReservoirSize
+}
+
+// Capabilities looks up the pump's model in the table of known pumps
+func (mmtpump *MedtronicPump) Capabilities() (*MMTPumpCapabilities, bool) {
+	caps, ok := knownPumps[mmtpump.ModelNumber]
+	return caps, ok
+}
+
+// Known returns whether the pump's model is in the table of known pumps
+func (mmtpump *MedtronicPump) Known() bool {
+	_, ok := knownPumps[mmtpump.ModelNumber]
+	return ok
+}
+
+// Supports returns whether the pump's model accepts a given command
+func (mmtpump *MedtronicPump) Supports(cmt CarelinkMessageType) bool {
+	caps, ok := mmtpump.Capabilities()
+	if !ok {
+		return false
+	}
+	return caps.Supports(cmt)
 }
 
 func (mmtpump *MedtronicPump) generation() int {
 	return mmtpump.ModelNumber % 100
 }
 
-// feature flags of the pump
+// feature flags of the pump, as listed in the table of known pumps
 
 func (mmtpump *MedtronicPump) NewRecordStyle() bool {
-	return mmtpump.Modern()
+	caps, ok := mmtpump.Capabilities()
+	return ok && caps.HistoryLayout == MMTHistoryLayoutModern
 }
 
 func (mmtpump *MedtronicPump) ASWTHOSOD() bool {
 	// appendsSquareWaveToHistoryOnStartOfDelivery
-	return mmtpump.Modern()
+	caps, ok := mmtpump.Capabilities()
+	return ok && caps.SquareWaveAtStart
 }
 
 func (mmtpump *MedtronicPump) HasMySentry() bool {
-	return mmtpump.Modern()
+	caps, ok := mmtpump.Capabilities()
+	return ok && caps.MySentry
 }
 
 func (mmtpump *MedtronicPump) HasLowSuspend() bool {
-	return mmtpump.generation() >= 51
+	caps, ok := mmtpump.Capabilities()
+	return ok && caps.LowSuspend
 }
 
 func (mmtpump *MedtronicPump) RBPSE() bool {
 	// recordsBasalProfileStartEvents
-	return mmtpump.Modern()
+	caps, ok := mmtpump.Capabilities()
+	return ok && caps.BasalProfileStartEvents
 }
 
 func (mmtpump *MedtronicPump) HasBolusErrorQuirk() bool {
-	// // On x15 models, a bolus in progress error is returned when bolusing, even though the bolus succeeds
-	return mmtpump.Modern()
+	// On x15 models, a bolus in progress error is returned when bolusing,
+	// even though the bolus succeeds
+	caps, ok := mmtpump.Capabilities()
+	return ok && caps.BolusErrorQuirk
 }
 
 // Modern returns whether the pump is considered "modern"
@@ -64,32 +164,375 @@ func (mmtpump *MedtronicPump) Modern() bool {
 	return mmtpump.generation() >= 23
 }
 
+// StrokesPerUnit returns the delivery precision of the pump, falling back
+// to the coarse precision of older models when the model isn't known
 func (mmtpump *MedtronicPump) StrokesPerUnit() int {
-	// /// Newer models allow higher precision delivery, and have bit packing to accomodate this.
-	if mmtpump.Modern() {
+	// Newer models allow higher precision delivery, and have bit packing
+	// to accomodate this.
+	caps, ok := mmtpump.Capabilities()
+	if !ok {
+		return 10
+	}
+	return caps.StrokesPerUnit
+}
+
+// BasalStrokesPerUnit returns the precision basal rates are counted in,
+// falling back to the 1/40 U/h every known model uses
+func (mmtpump *MedtronicPump) BasalStrokesPerUnit() int {
+	caps, ok := mmtpump.Capabilities()
+	if !ok {
 		return 40
 	}
-	return 10
-}
-
-var knownPumps = map[int]string{
-	508: "508",
-	511: "511",
-	711: "711",
-	512: "512",
-	712: "712",
-	515: "515",
-	715: "715",
-	522: "522",
-	722: "722",
-	523: "523",
-	723: "723",
-	530: "530",
-	730: "730",
-	540: "540",
-	740: "740",
-	551: "551",
-	751: "751",
-	554: "554",
-	754: "754",
+	return caps.BasalStrokesPerUnit
+}
+
+var (
+	// commands understood by every pump that speaks Carelink
+	mmtBaseCommands = []CarelinkMessageType{
+		CMTPumpAck,
+		CMTErrorResponse,
+		CMTPowerOn,
+		CMTButtonPress,
+		CMTGetPumpModel,
+		CMTReadTime,
+		CMTChangeTime,
+		CMTGetBattery,
+		CMTReadRemainingInsulin,
+		CMTReadFirmwareVersion,
+		CMTReadErrorStatus,
+		CMTGetHistoryPage,
+		CMTBolus,
+		CMTChangeTempBasal,
+		CMTReadTempBasal,
+		CMTSuspendResume,
+		CMTReadCarbUnits,
+		CMTReadBGUnits,
+		CMTReadCarbRatios,
+		CMTReadSensitivities,
+		CMTReadBGTargets,
+		CMTReadSettings,
+		CMTSetMaxBolus,
+		CMTSetMaxBasalRate,
+		CMTReadRemoteControlIDs,
+		CMTSetRemoteControlID,
+		CMTSetRemoteControlEnabled,
+	}
+	// x12 and newer have three basal profiles addressable by 512-byte reads
+	mmt512Commands = append(mmtBaseCommands[:len(mmtBaseCommands):len(mmtBaseCommands)],
+		CMTReadProfileSTD512,
+		CMTReadProfileA512,
+		CMTReadProfileB512,
+		CMTSetBasalProfileStandard,
+		CMTSetBasalProfileA,
+		CMTSetBasalProfileB,
+		CMTSelectBasalProfile,
+		CMTReadCurrentPageNumber,
+	)
+	// x15 and newer keep BG targets as ranges, and daily totals
+	mmt515Commands = append(mmt512Commands[:len(mmt512Commands):len(mmt512Commands)],
+		CMTReadBGTargets515,
+		CMTReadTodaysTotals,
+	)
+	// x22 and newer are sensor-augmented and keep glucose history
+	mmtSensorCommands = append(mmt515Commands[:len(mmt515Commands):len(mmt515Commands)],
+		CMTReadCurrentGlucosePage,
+		CMTGetGlucosePage,
+		CMTWriteGlucoseHistoryTimestamp,
+		CMTReadOtherDevicesIDs,
+		CMTReadOtherDevicesStatus,
+	)
+	// x23 and newer report status and settings changes
+	mmtModernCommands = append(mmtSensorCommands[:len(mmtSensorCommands):len(mmtSensorCommands)],
+		CMTReadPumpStatus,
+		CMTChangeTempBasalPercent,
+		CMTSettingsChangeCounter,
+		CMTReadCaptureEventEnabled,
+		CMTChangeCaptureEventEnable,
+	)
+)
+
+var knownPumps = map[int]*MMTPumpCapabilities{
+	508: {
+		ReservoirSize:       MMTPumpSizeLarge,
+		ReservoirUnits:      300,
+		Generation:          8,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		Commands:            mmtBaseCommands,
+	},
+	511: {
+		ReservoirSize:       MMTPumpSizeSmall,
+		ReservoirUnits:      176,
+		Generation:          11,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		Commands:            mmtBaseCommands,
+	},
+	711: {
+		ReservoirSize:       MMTPumpSizeLarge,
+		ReservoirUnits:      300,
+		Generation:          11,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		Commands:            mmtBaseCommands,
+	},
+	512: {
+		ReservoirSize:       MMTPumpSizeSmall,
+		ReservoirUnits:      176,
+		Generation:          12,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		Commands:            mmt512Commands,
+	},
+	712: {
+		ReservoirSize:       MMTPumpSizeLarge,
+		ReservoirUnits:      300,
+		Generation:          12,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		Commands:            mmt512Commands,
+	},
+	515: {
+		ReservoirSize:       MMTPumpSizeSmall,
+		ReservoirUnits:      176,
+		Generation:          15,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		BolusErrorQuirk:     true,
+		Commands:            mmt515Commands,
+	},
+	715: {
+		ReservoirSize:       MMTPumpSizeLarge,
+		ReservoirUnits:      300,
+		Generation:          15,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		BolusErrorQuirk:     true,
+		Commands:            mmt515Commands,
+	},
+	522: {
+		ReservoirSize:       MMTPumpSizeSmall,
+		ReservoirUnits:      176,
+		Generation:          22,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		Commands:            mmtSensorCommands,
+	},
+	722: {
+		ReservoirSize:       MMTPumpSizeLarge,
+		ReservoirUnits:      300,
+		Generation:          22,
+		BolusIncrement:      0.1,
+		BasalIncrement:      0.05,
+		MaxBasalRate:        35,
+		MaxBolus:            25,
+		StrokesPerUnit:      10,
+		BasalStrokesPerUnit: 40,
+		HistoryLayout:       MMTHistoryLayoutLegacy,
+		Commands:            mmtSensorCommands,
+	},
+	523: {
+		ReservoirSize:           MMTPumpSizeSmall,
+		ReservoirUnits:          176,
+		Generation:              23,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	723: {
+		ReservoirSize:           MMTPumpSizeLarge,
+		ReservoirUnits:          300,
+		Generation:              23,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	530: {
+		ReservoirSize:           MMTPumpSizeSmall,
+		ReservoirUnits:          176,
+		Generation:              30,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	730: {
+		ReservoirSize:           MMTPumpSizeLarge,
+		ReservoirUnits:          300,
+		Generation:              30,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	540: {
+		ReservoirSize:           MMTPumpSizeSmall,
+		ReservoirUnits:          176,
+		Generation:              40,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	740: {
+		ReservoirSize:           MMTPumpSizeLarge,
+		ReservoirUnits:          300,
+		Generation:              40,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	551: {
+		ReservoirSize:           MMTPumpSizeSmall,
+		ReservoirUnits:          176,
+		Generation:              51,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		LowSuspend:              true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	751: {
+		ReservoirSize:           MMTPumpSizeLarge,
+		ReservoirUnits:          300,
+		Generation:              51,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		LowSuspend:              true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	554: {
+		ReservoirSize:           MMTPumpSizeSmall,
+		ReservoirUnits:          176,
+		Generation:              54,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		LowSuspend:              true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
+	754: {
+		ReservoirSize:           MMTPumpSizeLarge,
+		ReservoirUnits:          300,
+		Generation:              54,
+		BolusIncrement:          0.025,
+		BasalIncrement:          0.025,
+		MaxBasalRate:            35,
+		MaxBolus:                25,
+		StrokesPerUnit:          40,
+		BasalStrokesPerUnit:     40,
+		HistoryLayout:           MMTHistoryLayoutModern,
+		MySentry:                true,
+		LowSuspend:              true,
+		BasalProfileStartEvents: true,
+		SquareWaveAtStart:       true,
+		Commands:                mmtModernCommands,
+	},
 }
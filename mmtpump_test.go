@@ -0,0 +1,86 @@
+// mmtpump_test.go contains tests of the table of known pumps
+
+package gorileylink
+
+import "testing"
+
+func TestKnownPumps(t *testing.T) {
+	for model, caps := range knownPumps {
+		pump := &MedtronicPump{ModelNumber: model}
+		if caps.Generation != model%100 {
+			t.Errorf("%d: generation %d", model, caps.Generation)
+		}
+		switch {
+		case model == 508:
+			// predates the 5xx/7xx naming and holds 300 U
+			if caps.ReservoirSize != MMTPumpSizeLarge || caps.ReservoirUnits != 300 {
+				t.Errorf("%d: reservoir %v/%d U", model, caps.ReservoirSize, caps.ReservoirUnits)
+			}
+		case model/100 == 5:
+			if caps.ReservoirSize != MMTPumpSizeSmall || caps.ReservoirUnits != 176 {
+				t.Errorf("%d: reservoir %v/%d U", model, caps.ReservoirSize, caps.ReservoirUnits)
+			}
+		case model/100 == 7:
+			if caps.ReservoirSize != MMTPumpSizeLarge || caps.ReservoirUnits != 300 {
+				t.Errorf("%d: reservoir %v/%d U", model, caps.ReservoirSize, caps.ReservoirUnits)
+			}
+		}
+		if pump.GetMaxReserviorSize() != caps.ReservoirSize {
+			t.Errorf("%d: GetMaxReserviorSize %v", model, pump.GetMaxReserviorSize())
+		}
+
+		modern := caps.Generation >= 23
+		wantStrokes := 10
+		if modern {
+			wantStrokes = 40
+		}
+		if pump.StrokesPerUnit() != wantStrokes {
+			t.Errorf("%d: %d strokes per unit", model, pump.StrokesPerUnit())
+		}
+		if pump.BasalStrokesPerUnit() != 40 {
+			t.Errorf("%d: %d basal strokes per unit", model, pump.BasalStrokesPerUnit())
+		}
+		if pump.NewRecordStyle() != modern || pump.HasMySentry() != modern ||
+			pump.RBPSE() != modern || pump.ASWTHOSOD() != modern {
+			t.Errorf("%d: modern feature flags don't match generation %d", model, caps.Generation)
+		}
+		if pump.HasBolusErrorQuirk() != (caps.Generation == 15) {
+			t.Errorf("%d: bolus error quirk %v", model, pump.HasBolusErrorQuirk())
+		}
+		if pump.HasLowSuspend() != (caps.Generation >= 51) {
+			t.Errorf("%d: low suspend %v", model, pump.HasLowSuspend())
+		}
+
+		if !pump.Supports(CMTGetPumpModel) || !pump.Supports(CMTReadSettings) || !pump.Supports(CMTBolus) {
+			t.Errorf("%d: missing base commands", model)
+		}
+		if pump.Supports(CMTReadProfileSTD512) != (caps.Generation >= 12) {
+			t.Errorf("%d: 512-byte profile reads %v", model, pump.Supports(CMTReadProfileSTD512))
+		}
+		if pump.Supports(CMTReadTodaysTotals) != (caps.Generation >= 15) {
+			t.Errorf("%d: today's totals %v", model, pump.Supports(CMTReadTodaysTotals))
+		}
+		if pump.Supports(CMTReadBGTargets515) != (caps.Generation >= 15) {
+			t.Errorf("%d: BG target ranges %v", model, pump.Supports(CMTReadBGTargets515))
+		}
+		if pump.Supports(CMTGetGlucosePage) != (caps.Generation >= 22) {
+			t.Errorf("%d: glucose pages %v", model, pump.Supports(CMTGetGlucosePage))
+		}
+		if pump.Supports(CMTReadPumpStatus) != modern {
+			t.Errorf("%d: pump status %v", model, pump.Supports(CMTReadPumpStatus))
+		}
+	}
+}
+
+func TestUnknownPump(t *testing.T) {
+	pump := &MedtronicPump{ModelNumber: 599}
+	if pump.Known() || pump.Supports(CMTGetPumpModel) || pump.HasMySentry() || pump.HasBolusErrorQuirk() {
+		t.Error("unknown pump has capabilities")
+	}
+	if pump.StrokesPerUnit() != 10 {
+		t.Errorf("unknown pump has %d strokes per unit", pump.StrokesPerUnit())
+	}
+	if pump.GetMaxReserviorSize() != MMTPumpSizeUnknown {
+		t.Errorf("unknown pump reservoir %v", pump.GetMaxReserviorSize())
+	}
+}
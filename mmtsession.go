@@ -0,0 +1,289 @@
+// mmtsession.go contains the exchange of Carelink messages with a pump
+
+package gorileylink
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// how long to listen for a pump reply to a single packet
+	mmtListenTimeout = 200 * time.Millisecond
+	// how many times the CC retransmits when the pump doesn't reply
+	mmtRetries = 3
+	// how long the short wakeup burst is listened for
+	mmtWakeupListenTimeout = 15 * time.Second
+	// how long the pump is asked to keep its radio on
+	mmtWakeupDuration = 10 * time.Minute
+)
+
+// MMTPumpSession binds a RileyLink to a specific Medtronic pump
+type MMTPumpSession struct {
+	rileylink PacketRadio
+	pumpID    []byte
+	Pump      *MedtronicPump
+	// Location is the time zone the pump clock is kept in
+	Location   *time.Location
+	awakeUntil time.Time
+}
+
+// NewMMTPumpSession creates a session with the pump of the given serial.
+// The pump may be nil if the model isn't known yet; ReadPumpModel will
+// then fill it in
+func NewMMTPumpSession(crl PacketRadio, pump *MedtronicPump, pumpID string) (*MMTPumpSession, error) {
+	id, err := ParsePumpID(pumpID)
+	if err != nil {
+		return nil, err
+	}
+	if pump == nil {
+		pump = &MedtronicPump{}
+	}
+	return &MMTPumpSession{
+		rileylink: crl,
+		pumpID:    id,
+		Pump:      pump,
+		Location:  time.Local,
+	}, nil
+}
+
+// exchange sends one message and returns the pump's reply to it
+func (mps *MMTPumpSession) exchange(msg *CarelinkMessage, repeat byte, timeout time.Duration, retries byte) (*CarelinkMessage, error) {
+	packet := append(Encode4b6b(msg.Packet(mps.pumpID)), 0x00)
+	response, err := mps.rileylink.SendAndListen(RLPCPump, packet, repeat, 0, RLPCPump, timeout, retries, 0)
+	if err != nil {
+		return nil, err
+	} else if response.Result != RLRSuccess {
+		return nil, fmt.Errorf("Bad result: %v", response.Result)
+	} else if len(response.Payload) < 2 {
+		return nil, fmt.Errorf("short response: %x", response.Payload)
+	}
+	// payload is RF RSSI, packet number, then the packet
+	pumpID, reply, err := ParseCarelinkPacket(Decode4b6b(response.Payload[2:]))
+	if err != nil {
+		return nil, err
+	} else if !bytes.Equal(pumpID, mps.pumpID) {
+		return nil, fmt.Errorf("reply from another pump: %x", pumpID)
+	}
+	log.WithFields(log.Fields{
+		"sent":     msg.MessageType,
+		"received": reply.MessageType,
+	}).Debug("Carelink exchange")
+	if reply.MessageType == CMTErrorResponse {
+		return nil, decodePumpError(msg.MessageType, reply.Data)
+	}
+	return reply, nil
+}
+
+// send exchanges one message with the pump using the usual timing
+func (mps *MMTPumpSession) send(msg *CarelinkMessage) (*CarelinkMessage, error) {
+	return mps.exchange(msg, 0, mmtListenTimeout, mmtRetries)
+}
+
+// sendOnce exchanges one message with the pump without the RileyLink
+// retransmitting it if the reply is lost
+func (mps *MMTPumpSession) sendOnce(msg *CarelinkMessage) (*CarelinkMessage, error) {
+	return mps.exchange(msg, 0, mmtListenTimeout, 0)
+}
+
+// sendOnly transmits a message without waiting for any reply
+func (mps *MMTPumpSession) sendOnly(msg *CarelinkMessage) error {
+	packet := append(Encode4b6b(msg.Packet(mps.pumpID)), 0x00)
+	return mps.rileylink.SendPacket(RLPCPump, packet, 0, 0, 0)
+}
+
+// Wakeup turns the pump's radio on if it isn't known to be on already
+func (mps *MMTPumpSession) Wakeup() error {
+	if time.Now().Before(mps.awakeUntil) {
+		return nil
+	}
+	// the pump may have been woken by someone else
+	_, err := mps.send(NewCarelinkShortMessage(CMTGetPumpModel))
+	if err == nil {
+		log.Debug("pump already awake")
+		mps.awakeUntil = time.Now().Add(time.Minute)
+		return nil
+	}
+	log.Debug("waking pump")
+	reply, err := mps.exchange(NewCarelinkShortMessage(CMTPowerOn), 255, mmtWakeupListenTimeout, 0)
+	if err != nil {
+		return fmt.Errorf("wakeup failed: %v", err)
+	} else if reply.MessageType != CMTPumpAck {
+		return fmt.Errorf("wakeup failed: unexpected reply %#x", reply.MessageType)
+	}
+	minutes := byte(mmtWakeupDuration / time.Minute)
+	reply, err = mps.send(NewCarelinkParamMessage(CMTPowerOn, []byte{0x01, minutes}))
+	if err != nil {
+		return fmt.Errorf("wakeup failed: %v", err)
+	} else if reply.MessageType != CMTPumpAck {
+		return fmt.Errorf("wakeup failed: unexpected reply %#x", reply.MessageType)
+	}
+	mps.awakeUntil = time.Now().Add(mmtWakeupDuration)
+	return nil
+}
+
+// runCommand wakes the pump and runs a command on it, returning the reply.
+// Commands with parameters are announced by the bare command, which the
+// pump acknowledges, before the parameters are sent
+func (mps *MMTPumpSession) runCommand(cmt CarelinkMessageType, params []byte) (*CarelinkMessage, error) {
+	err := mps.Wakeup()
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		return mps.send(NewCarelinkShortMessage(cmt))
+	}
+	reply, err := mps.send(NewCarelinkShortMessage(cmt))
+	if err != nil {
+		return nil, err
+	} else if reply.MessageType != CMTPumpAck {
+		return nil, fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+	}
+	return mps.send(NewCarelinkParamMessage(cmt, params))
+}
+
+// readFrames collects a reply spread over numbered data frames, each of
+// which is a frame number (high bit set on the last) and 64 bytes of
+// content.  The pump sends the next frame when the last one is ACKed
+func (mps *MMTPumpSession) readFrames(cmt CarelinkMessageType, params []byte, ackLast bool) ([]byte, error) {
+	reply, err := mps.runCommand(cmt, params)
+	if err != nil {
+		return nil, err
+	}
+	var contents []byte
+	for expected := byte(1); ; expected++ {
+		if reply.MessageType != cmt {
+			return nil, fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+		} else if len(reply.Data) < carelinkBodyLength {
+			return nil, fmt.Errorf("%#x: short frame: %x", cmt, reply.Data)
+		} else if reply.Data[0]&0x7f != expected {
+			return nil, fmt.Errorf("%#x: expected frame %d, got %d", cmt, expected, reply.Data[0]&0x7f)
+		}
+		contents = append(contents, reply.Data[1:carelinkBodyLength]...)
+		if reply.Data[0]&0x80 != 0 {
+			break
+		}
+		reply, err = mps.send(NewCarelinkShortMessage(CMTPumpAck))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ackLast {
+		err = mps.sendOnly(NewCarelinkShortMessage(CMTPumpAck))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return contents, nil
+}
+
+// writeFrames sends contents as numbered 64-byte data frames, the first
+// after announcing the command and the rest each once the previous is ACKed
+func (mps *MMTPumpSession) writeFrames(cmt CarelinkMessageType, contents []byte) error {
+	var frames [][]byte
+	for n := 0; n*64 < len(contents); n++ {
+		frame := make([]byte, carelinkBodyLength)
+		frame[0] = byte(n + 1)
+		copy(frame[1:], contents[n*64:])
+		frames = append(frames, frame)
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("%#x: nothing to write", cmt)
+	}
+	frames[len(frames)-1][0] |= 0x80
+	err := mps.Wakeup()
+	if err != nil {
+		return err
+	}
+	reply, err := mps.send(NewCarelinkShortMessage(cmt))
+	if err != nil {
+		return err
+	} else if reply.MessageType != CMTPumpAck {
+		return fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+	}
+	for _, frame := range frames {
+		reply, err = mps.send(&CarelinkMessage{cmt, frame})
+		if err != nil {
+			return err
+		} else if reply.MessageType != CMTPumpAck {
+			return fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+		}
+	}
+	return nil
+}
+
+// readCommand runs a parameterless command that the pump answers in kind
+func (mps *MMTPumpSession) readCommand(cmt CarelinkMessageType) ([]byte, error) {
+	reply, err := mps.runCommand(cmt, nil)
+	if err != nil {
+		return nil, err
+	} else if reply.MessageType != cmt {
+		return nil, fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+	}
+	return reply.Data, nil
+}
+
+// setCommand runs a command with parameters that the pump acknowledges
+func (mps *MMTPumpSession) setCommand(cmt CarelinkMessageType, params []byte) error {
+	reply, err := mps.runCommand(cmt, params)
+	if err != nil {
+		return err
+	} else if reply.MessageType != CMTPumpAck {
+		return fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+	}
+	return nil
+}
+
+// deliverCommand runs a command with parameters like setCommand, except
+// that the parameters are transmitted exactly once.  When their reply is
+// lost the pump may well have acted on them, so it is up to the caller to
+// find out before trying again
+func (mps *MMTPumpSession) deliverCommand(cmt CarelinkMessageType, params []byte) error {
+	err := mps.Wakeup()
+	if err != nil {
+		return err
+	}
+	reply, err := mps.send(NewCarelinkShortMessage(cmt))
+	if err != nil {
+		return err
+	} else if reply.MessageType != CMTPumpAck {
+		return fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+	}
+	reply, err = mps.sendOnce(NewCarelinkParamMessage(cmt, params))
+	if err != nil {
+		return err
+	} else if reply.MessageType != CMTPumpAck {
+		return fmt.Errorf("%#x: unexpected reply %#x", cmt, reply.MessageType)
+	}
+	return nil
+}
+
+// ReadPumpModel asks the pump for its model number and records it
+func (mps *MMTPumpSession) ReadPumpModel() (int, error) {
+	body, err := mps.readCommand(CMTGetPumpModel)
+	if err != nil {
+		return 0, err
+	} else if len(body) < 2 || len(body) < 2+int(body[1]) {
+		return 0, fmt.Errorf("short model reply: %x", body)
+	}
+	model, err := strconv.Atoi(string(body[2 : 2+int(body[1])]))
+	if err != nil {
+		return 0, err
+	}
+	mps.Pump.ModelNumber = model
+	return model, nil
+}
+
+// ensureModel reads the pump model if the session doesn't know it yet,
+// since many replies are laid out differently across generations
+func (mps *MMTPumpSession) ensureModel() error {
+	if mps.Pump.ModelNumber != 0 {
+		return nil
+	}
+	_, err := mps.ReadPumpModel()
+	return err
+}
@@ -0,0 +1,154 @@
+// mmtsettings.go contains reading the pump's configuration
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// MMTBasalProfile selects one of the pump's basal patterns
+type MMTBasalProfile byte
+
+const (
+	MMTBasalProfileStandard MMTBasalProfile = 0x00
+	MMTBasalProfileA        MMTBasalProfile = 0x01
+	MMTBasalProfileB        MMTBasalProfile = 0x02
+)
+
+func (mbp MMTBasalProfile) String() string {
+	switch mbp {
+	case MMTBasalProfileStandard:
+		return "MMTBasalProfileStandard"
+	case MMTBasalProfileA:
+		return "MMTBasalProfileA"
+	case MMTBasalProfileB:
+		return "MMTBasalProfileB"
+	default:
+		return "MMTBasalProfileUNKNOWN"
+	}
+}
+
+// MMTTempBasalType is how temporary basal rates are programmed
+type MMTTempBasalType byte
+
+const (
+	// MMTTempBasalAbsolute programs temp basals in U/h
+	MMTTempBasalAbsolute MMTTempBasalType = 0x00
+	// MMTTempBasalPercent programs temp basals as a percentage of basal
+	MMTTempBasalPercent MMTTempBasalType = 0x01
+)
+
+func (mtbt MMTTempBasalType) String() string {
+	switch mtbt {
+	case MMTTempBasalAbsolute:
+		return "MMTTempBasalAbsolute"
+	case MMTTempBasalPercent:
+		return "MMTTempBasalPercent"
+	default:
+		return "MMTTempBasalTypeUNKNOWN"
+	}
+}
+
+// MMTLowReservoirWarning is what the low reservoir warning is based on
+type MMTLowReservoirWarning byte
+
+const (
+	MMTLowReservoirTime  MMTLowReservoirWarning = 0x00
+	MMTLowReservoirUnits MMTLowReservoirWarning = 0x01
+)
+
+func (mlrw MMTLowReservoirWarning) String() string {
+	switch mlrw {
+	case MMTLowReservoirTime:
+		return "MMTLowReservoirTime"
+	case MMTLowReservoirUnits:
+		return "MMTLowReservoirUnits"
+	default:
+		return "MMTLowReservoirWarningUNKNOWN"
+	}
+}
+
+// MMTAlarmSettings is how the pump gets the user's attention
+type MMTAlarmSettings struct {
+	// Vibrate is set when alarms vibrate instead of beep
+	Vibrate bool
+	// Volume is the beep volume when not vibrating
+	Volume                   int
+	LowReservoirWarning      MMTLowReservoirWarning
+	LowReservoirWarningPoint int
+}
+
+// PumpSettings is the decoded reply to CMTReadSettings
+type PumpSettings struct {
+	// MaxBasal is in U/h
+	MaxBasal float64
+	// MaxBolus is in U
+	MaxBolus float64
+	// InsulinActionCurve is the insulin duration used by the bolus wizard
+	InsulinActionCurve time.Duration
+	ActiveBasalProfile MMTBasalProfile
+	TempBasalType      MMTTempBasalType
+	// TempBasalPercent is the last programmed percent temp basal
+	TempBasalPercent int
+	Alarm            MMTAlarmSettings
+	// AutoOff is how long without a button press before the pump stops
+	// delivery; zero when disabled
+	AutoOff         time.Duration
+	PatternsEnabled bool
+}
+
+const (
+	mmtMaxBolusMultiplier = 10
+	mmtMaxBasalMultiplier = 40
+)
+
+// decodePumpSettings unpacks a CMTReadSettings body.  x23 and newer pumps
+// insert the bolus scroll step size ahead of the max bolus, which moves
+// the max bolus and max basal along by one; every other field stays put
+func decodePumpSettings(body []byte, pump *MedtronicPump) (*PumpSettings, error) {
+	shift := 0
+	if pump.Modern() {
+		shift = 1
+	}
+	if len(body) < 21 {
+		return nil, fmt.Errorf("short settings reply: %x", body)
+	}
+	// skip the length byte
+	data := body[1:]
+	settings := &PumpSettings{
+		MaxBolus:           float64(data[5+shift]) / mmtMaxBolusMultiplier,
+		MaxBasal:           float64(binary.BigEndian.Uint16(data[6+shift:8+shift])) / mmtMaxBasalMultiplier,
+		PatternsEnabled:    data[10] == 1,
+		ActiveBasalProfile: MMTBasalProfile(data[11]),
+		TempBasalType:      MMTTempBasalType(data[14]),
+		TempBasalPercent:   int(data[15]),
+		InsulinActionCurve: time.Duration(data[17]) * time.Hour,
+		AutoOff:            time.Duration(data[0]) * time.Hour,
+		Alarm: MMTAlarmSettings{
+			LowReservoirWarning:      MMTLowReservoirWarning(data[18]),
+			LowReservoirWarningPoint: int(data[19]),
+		},
+	}
+	// a volume of 0xff is how vibrate is recorded
+	if data[1] == 0xff {
+		settings.Alarm.Vibrate = true
+	} else {
+		settings.Alarm.Volume = int(data[1])
+	}
+	return settings, nil
+}
+
+// ReadSettings returns the pump's configuration
+func (mps *MMTPumpSession) ReadSettings() (*PumpSettings, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	}
+	body, err := mps.readCommand(CMTReadSettings)
+	if err != nil {
+		return nil, err
+	}
+	return decodePumpSettings(body, mps.Pump)
+}
@@ -0,0 +1,95 @@
+// mmtsettings_test.go contains tests of decoding the pump's configuration
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// mustDecodeHex turns a hex fixture into bytes
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad fixture %q: %v", s, err)
+	}
+	return b
+}
+
+// a CMTReadSettings reply captured from a x23 and newer pump, as used in
+// Loop's ReadSettingsCarelinkMessageBody tests: max basal 3.5 U/h, max
+// bolus 15 U, standard profile, 4 hour insulin action curve, low
+// reservoir warning at 20 U
+const settingsCaptureModern = "a7594040c0" +
+	"1900010001010096008c00000000000064010400140019010101" +
+	"000000000000000000000000000000000000000000000000000000000000000000000000000000" +
+	"e9"
+
+func TestDecodePumpSettingsModern(t *testing.T) {
+	_, msg, err := ParseCarelinkPacket(mustDecodeHex(t, settingsCaptureModern))
+	if err != nil {
+		t.Fatal(err)
+	} else if msg.MessageType != CMTReadSettings {
+		t.Fatalf("captured a %v", msg.MessageType)
+	}
+	settings, err := decodePumpSettings(msg.Data, &MedtronicPump{ModelNumber: 554})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxBasal != 3.5 {
+		t.Errorf("max basal %v U/h", settings.MaxBasal)
+	}
+	if settings.MaxBolus != 15 {
+		t.Errorf("max bolus %v U", settings.MaxBolus)
+	}
+	if settings.ActiveBasalProfile != MMTBasalProfileStandard {
+		t.Errorf("profile %v", settings.ActiveBasalProfile)
+	}
+	if settings.InsulinActionCurve != 4*time.Hour {
+		t.Errorf("insulin action curve %v", settings.InsulinActionCurve)
+	}
+	if settings.TempBasalType != MMTTempBasalAbsolute || settings.TempBasalPercent != 100 {
+		t.Errorf("temp basal %v %d%%", settings.TempBasalType, settings.TempBasalPercent)
+	}
+	if settings.Alarm.LowReservoirWarning != MMTLowReservoirTime || settings.Alarm.LowReservoirWarningPoint != 20 {
+		t.Errorf("low reservoir warning %v at %d", settings.Alarm.LowReservoirWarning, settings.Alarm.LowReservoirWarningPoint)
+	}
+	if settings.Alarm.Vibrate || settings.Alarm.Volume != 1 {
+		t.Errorf("alarm %+v", settings.Alarm)
+	}
+}
+
+func TestDecodePumpSettingsLegacy(t *testing.T) {
+	// the captured settings above as a x22 pump lays them out, without
+	// the bolus scroll step ahead of the max bolus; laid out by hand, not
+	// captured
+	body := mustDecodeHex(t, "15"+"0001000101"+"96"+"008c"+"0000"+"01"+"01"+"0000"+"0164"+"01"+"04"+"0014"+"00")
+	settings, err := decodePumpSettings(body, &MedtronicPump{ModelNumber: 522})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxBasal != 3.5 || settings.MaxBolus != 15 {
+		t.Errorf("max basal %v U/h, max bolus %v U", settings.MaxBasal, settings.MaxBolus)
+	}
+	if settings.ActiveBasalProfile != MMTBasalProfileA || !settings.PatternsEnabled {
+		t.Errorf("profile %v, patterns %v", settings.ActiveBasalProfile, settings.PatternsEnabled)
+	}
+	if settings.TempBasalType != MMTTempBasalPercent || settings.TempBasalPercent != 100 {
+		t.Errorf("temp basal %v %d%%", settings.TempBasalType, settings.TempBasalPercent)
+	}
+	if settings.InsulinActionCurve != 4*time.Hour {
+		t.Errorf("insulin action curve %v", settings.InsulinActionCurve)
+	}
+	if settings.Alarm.LowReservoirWarningPoint != 20 {
+		t.Errorf("low reservoir warning at %d", settings.Alarm.LowReservoirWarningPoint)
+	}
+}
+
+func TestDecodePumpSettingsShort(t *testing.T) {
+	_, err := decodePumpSettings(make([]byte, 20), &MedtronicPump{ModelNumber: 554})
+	if err == nil {
+		t.Error("short reply decoded")
+	}
+}
@@ -0,0 +1,103 @@
+// mmtstatus.go contains the quick status queries of the pump
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MMTBatteryStatus is the pump's own judgement of its battery
+type MMTBatteryStatus byte
+
+const (
+	MMTBatteryNormal MMTBatteryStatus = 0x00
+	MMTBatteryLow    MMTBatteryStatus = 0x01
+)
+
+func (mbs MMTBatteryStatus) String() string {
+	switch mbs {
+	case MMTBatteryNormal:
+		return "MMTBatteryNormal"
+	case MMTBatteryLow:
+		return "MMTBatteryLow"
+	default:
+		return "MMTBatteryStatusUNKNOWN"
+	}
+}
+
+// MMTBattery is the state of the pump's AAA battery
+type MMTBattery struct {
+	Status MMTBatteryStatus
+	// Voltage is in V
+	Voltage float64
+}
+
+// MMTReservoir is the insulin left in the pump
+type MMTReservoir struct {
+	Strokes int
+	// Units is in U
+	Units float64
+}
+
+// MMTPumpStatus is what the pump is doing right now
+type MMTPumpStatus struct {
+	// Normal is set when the basal schedule is running normally
+	Normal    bool
+	Bolusing  bool
+	Suspended bool
+}
+
+// GetBattery returns the pump battery status and voltage
+func (mps *MMTPumpSession) GetBattery() (*MMTBattery, error) {
+	body, err := mps.readCommand(CMTGetBattery)
+	if err != nil {
+		return nil, err
+	} else if len(body) < 4 {
+		return nil, fmt.Errorf("short battery reply: %x", body)
+	}
+	return &MMTBattery{
+		MMTBatteryStatus(body[1]),
+		float64(binary.BigEndian.Uint16(body[2:4])) / 100,
+	}, nil
+}
+
+// ReadRemainingInsulin returns how much insulin is left in the reservoir
+func (mps *MMTPumpSession) ReadRemainingInsulin() (*MMTReservoir, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	}
+	body, err := mps.readCommand(CMTReadRemainingInsulin)
+	if err != nil {
+		return nil, err
+	}
+	// modern pumps have a wider field for their finer strokes
+	offset := 1
+	if mps.Pump.Modern() {
+		offset = 3
+	}
+	if len(body) < offset+2 {
+		return nil, fmt.Errorf("short reservoir reply: %x", body)
+	}
+	strokes := int(binary.BigEndian.Uint16(body[offset : offset+2]))
+	return &MMTReservoir{
+		strokes,
+		float64(strokes) / float64(mps.Pump.StrokesPerUnit()),
+	}, nil
+}
+
+// ReadPumpStatus returns whether the pump is running, bolusing or suspended
+func (mps *MMTPumpSession) ReadPumpStatus() (*MMTPumpStatus, error) {
+	body, err := mps.readCommand(CMTReadPumpStatus)
+	if err != nil {
+		return nil, err
+	} else if len(body) < 4 {
+		return nil, fmt.Errorf("short status reply: %x", body)
+	}
+	return &MMTPumpStatus{
+		body[1] == 0x03,
+		body[2] > 0,
+		body[3] > 0,
+	}, nil
+}
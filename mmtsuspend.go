@@ -0,0 +1,156 @@
+// mmtsuspend.go contains suspending and resuming insulin delivery
+
+package gorileylink
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MMTButton is a key on the pump, as pressed with CMTButtonPress
+type MMTButton byte
+
+const (
+	MMTButtonEasy MMTButton = 0x00
+	MMTButtonEsc  MMTButton = 0x01
+	MMTButtonAct  MMTButton = 0x02
+	MMTButtonUp   MMTButton = 0x03
+	MMTButtonDown MMTButton = 0x04
+)
+
+func (mb MMTButton) String() string {
+	switch mb {
+	case MMTButtonEasy:
+		return "MMTButtonEasy"
+	case MMTButtonEsc:
+		return "MMTButtonEsc"
+	case MMTButtonAct:
+		return "MMTButtonAct"
+	case MMTButtonUp:
+		return "MMTButtonUp"
+	case MMTButtonDown:
+		return "MMTButtonDown"
+	default:
+		return "MMTButtonUNKNOWN"
+	}
+}
+
+// PressButton presses a key on the pump as if by hand.  The menus differ
+// between models and firmware, so walking them is left to the caller
+func (mps *MMTPumpSession) PressButton(button MMTButton) error {
+	return mps.setCommand(CMTButtonPress, []byte{byte(button)})
+}
+
+// Suspend stops all insulin delivery until Resume is called
+func (mps *MMTPumpSession) Suspend() error {
+	return mps.setSuspended(true)
+}
+
+// Resume restarts insulin delivery after Suspend.  Pumps with low glucose
+// suspend stop themselves and raise an alarm; that alarm must be cleared
+// (see ClearAlert) before Resume will override the pump's own decision
+func (mps *MMTPumpSession) Resume() error {
+	err := mps.checkLowSuspend()
+	if err != nil {
+		return err
+	}
+	return mps.setSuspended(false)
+}
+
+// SuspendWithButtons suspends delivery by pressing keys through the pump's
+// menus, as a user would, for pumps that ignore CMTSuspendResume.  The
+// menus differ between models and firmware, so the keys are the caller's
+func (mps *MMTPumpSession) SuspendWithButtons(keys []MMTButton) error {
+	err := mps.pressButtons(keys)
+	if err != nil {
+		return err
+	}
+	return mps.confirmSuspended(true)
+}
+
+// ResumeWithButtons resumes delivery by pressing keys through the pump's
+// menus, refusing like Resume while a low glucose suspend is in force
+func (mps *MMTPumpSession) ResumeWithButtons(keys []MMTButton) error {
+	err := mps.checkLowSuspend()
+	if err != nil {
+		return err
+	}
+	err = mps.pressButtons(keys)
+	if err != nil {
+		return err
+	}
+	return mps.confirmSuspended(false)
+}
+
+// pressButtons presses keys in turn
+func (mps *MMTPumpSession) pressButtons(keys []MMTButton) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys to press")
+	}
+	for i, key := range keys {
+		err := mps.PressButton(key)
+		if err != nil {
+			return fmt.Errorf("key %d (%v): %v", i, key, err)
+		}
+	}
+	return nil
+}
+
+// checkLowSuspend refuses while a pump with low glucose suspend is raising
+// its low suspend alarm.  An alarm this package doesn't know could be that
+// alarm on firmware it hasn't seen, so it is refused too
+func (mps *MMTPumpSession) checkLowSuspend() error {
+	err := mps.ensureModel()
+	if err != nil {
+		return err
+	} else if !mps.Pump.HasLowSuspend() {
+		return nil
+	}
+	alarm, err := mps.ReadErrorStatus()
+	if err != nil {
+		return err
+	} else if alarm == MMTAlarmLowGlucoseSuspend || !alarm.Known() {
+		return fmt.Errorf("resume refused: pump is raising %v (%#x)", alarm, byte(alarm))
+	}
+	return nil
+}
+
+// setSuspended sends CMTSuspendResume and, where the pump reports its
+// status, reads it back to make sure the pump is in the new state
+func (mps *MMTPumpSession) setSuspended(suspend bool) error {
+	err := mps.ensureModel()
+	if err != nil {
+		return err
+	} else if !mps.Pump.Supports(CMTSuspendResume) {
+		return fmt.Errorf("pump model %d can't be suspended remotely", mps.Pump.ModelNumber)
+	}
+	param := byte(0x00)
+	if suspend {
+		param = 0x01
+	}
+	err = mps.setCommand(CMTSuspendResume, []byte{param})
+	if err != nil {
+		return err
+	}
+	return mps.confirmSuspended(suspend)
+}
+
+// confirmSuspended reads the pump status back, where the pump reports it,
+// to make sure the pump is in the new state
+func (mps *MMTPumpSession) confirmSuspended(suspend bool) error {
+	err := mps.ensureModel()
+	if err != nil {
+		return err
+	} else if !mps.Pump.Supports(CMTReadPumpStatus) {
+		log.WithField("suspended", suspend).Debug("pump can't report status, state unconfirmed")
+		return nil
+	}
+	status, err := mps.ReadPumpStatus()
+	if err != nil {
+		return err
+	} else if status.Suspended != suspend {
+		return fmt.Errorf("pump did not change state: suspended is %v", status.Suspended)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// mmttempbasal.go contains setting, reading and cancelling temp basals
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// temp basals run in half-hour segments up to a day
+	mmtTempBasalSegment     = 30 * time.Minute
+	mmtTempBasalMaxDuration = 24 * time.Hour
+	mmtTempBasalMaxPercent  = 200
+)
+
+// MMTTempBasal is a temporary basal rate, either in U/h or in percent
+type MMTTempBasal struct {
+	Type MMTTempBasalType
+	// Rate is in U/h for an absolute temp basal
+	Rate float64
+	// Percent is of the scheduled basal for a percent temp basal
+	Percent int
+	// Duration is the time requested, or remaining when read back
+	Duration time.Duration
+}
+
+// ReadTempBasal returns the running temp basal; a Duration of zero means
+// none is running
+func (mps *MMTPumpSession) ReadTempBasal() (*MMTTempBasal, error) {
+	body, err := mps.readCommand(CMTReadTempBasal)
+	if err != nil {
+		return nil, err
+	} else if len(body) < 7 {
+		return nil, fmt.Errorf("short temp basal reply: %x", body)
+	}
+	tempBasal := &MMTTempBasal{
+		Type:     MMTTempBasalType(body[1]),
+		Duration: time.Duration(binary.BigEndian.Uint16(body[5:7])) * time.Minute,
+	}
+	switch tempBasal.Type {
+	case MMTTempBasalAbsolute:
+		tempBasal.Rate = float64(binary.BigEndian.Uint16(body[3:5])) / float64(mps.Pump.BasalStrokesPerUnit())
+	case MMTTempBasalPercent:
+		tempBasal.Percent = int(body[2])
+	default:
+		return nil, fmt.Errorf("unknown temp basal type: %v", tempBasal.Type)
+	}
+	return tempBasal, nil
+}
+
+// validateTempBasal checks a temp basal against the pump's limits
+func (mps *MMTPumpSession) validateTempBasal(tempBasal *MMTTempBasal) error {
+	if tempBasal.Duration < 0 || tempBasal.Duration > mmtTempBasalMaxDuration {
+		return fmt.Errorf("temp basal duration %v is outside 0-%v", tempBasal.Duration, mmtTempBasalMaxDuration)
+	} else if tempBasal.Duration%mmtTempBasalSegment != 0 {
+		return fmt.Errorf("temp basal duration %v is not in %v steps", tempBasal.Duration, mmtTempBasalSegment)
+	}
+	switch tempBasal.Type {
+	case MMTTempBasalAbsolute:
+		settings, err := mps.ReadSettings()
+		if err != nil {
+			return err
+		}
+		caps, ok := mps.Pump.Capabilities()
+		if !ok {
+			return fmt.Errorf("unknown pump model: %d", mps.Pump.ModelNumber)
+		}
+		if tempBasal.Rate < 0 || tempBasal.Rate > settings.MaxBasal {
+			return fmt.Errorf("temp basal rate %v U/h is outside 0-%v U/h", tempBasal.Rate, settings.MaxBasal)
+		}
+		steps := tempBasal.Rate / caps.BasalIncrement
+		if math.Abs(steps-math.Round(steps)) > 1e-6 {
+			return fmt.Errorf("temp basal rate %v U/h is not a multiple of %v U/h", tempBasal.Rate, caps.BasalIncrement)
+		}
+	case MMTTempBasalPercent:
+		err := mps.ensureModel()
+		if err != nil {
+			return err
+		} else if !mps.Pump.Supports(CMTChangeTempBasalPercent) {
+			return fmt.Errorf("pump model %d has no percent temp basals", mps.Pump.ModelNumber)
+		} else if tempBasal.Percent < 0 || tempBasal.Percent > mmtTempBasalMaxPercent {
+			return fmt.Errorf("temp basal %d%% is outside 0-%d%%", tempBasal.Percent, mmtTempBasalMaxPercent)
+		}
+	default:
+		return fmt.Errorf("unknown temp basal type: %v", tempBasal.Type)
+	}
+	return nil
+}
+
+// SetTempBasal validates and starts a temp basal, then reads it back to
+// make sure the pump is running what was asked for.  A zero Duration
+// cancels any running temp basal, which needs no validating: it is sent
+// as a zero absolute temp basal, which every model accepts whatever its
+// settings
+func (mps *MMTPumpSession) SetTempBasal(tempBasal MMTTempBasal) (*MMTTempBasal, error) {
+	var err error
+	if tempBasal.Duration != 0 {
+		err = mps.validateTempBasal(&tempBasal)
+		if err != nil {
+			return nil, err
+		}
+	}
+	segments := byte(tempBasal.Duration / mmtTempBasalSegment)
+	if tempBasal.Duration == 0 {
+		err = mps.setCommand(CMTChangeTempBasal, []byte{0x00, 0x00, 0x00})
+	} else if tempBasal.Type == MMTTempBasalPercent {
+		err = mps.setCommand(CMTChangeTempBasalPercent, []byte{byte(tempBasal.Percent), segments})
+	} else {
+		strokes := uint16(math.Round(tempBasal.Rate * float64(mps.Pump.BasalStrokesPerUnit())))
+		err = mps.setCommand(CMTChangeTempBasal, []byte{byte(strokes >> 8), byte(strokes), segments})
+	}
+	if err != nil {
+		return nil, err
+	}
+	running, err := mps.ReadTempBasal()
+	if err != nil {
+		return nil, fmt.Errorf("temp basal read-back failed: %v", err)
+	}
+	log.WithFields(log.Fields{
+		"requested": tempBasal,
+		"running":   running,
+	}).Debug("SetTempBasal")
+	if tempBasal.Duration == 0 {
+		if running.Duration != 0 {
+			return running, fmt.Errorf("temp basal still running after cancel: %v left", running.Duration)
+		}
+		return running, nil
+	}
+	// the pump counts down in minutes, so allow for one having passed
+	if running.Type != tempBasal.Type ||
+		(running.Type == MMTTempBasalAbsolute && math.Abs(running.Rate-tempBasal.Rate) > 1e-6) ||
+		(running.Type == MMTTempBasalPercent && running.Percent != tempBasal.Percent) ||
+		running.Duration > tempBasal.Duration ||
+		running.Duration < tempBasal.Duration-time.Minute {
+		return running, fmt.Errorf("pump is running a different temp basal than requested")
+	}
+	return running, nil
+}
+
+// CancelTempBasal stops any running temp basal
+func (mps *MMTPumpSession) CancelTempBasal() error {
+	_, err := mps.SetTempBasal(MMTTempBasal{Type: MMTTempBasalAbsolute})
+	return err
+}
@@ -0,0 +1,114 @@
+// mmttune.go contains scanning for the frequency a pump is heard best on
+
+package gorileylink
+
+import (
+	"bytes"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// how far apart the frequencies scanned are
+	mmtTuneStep = 50000
+	// how many packets are sent on each frequency scanned
+	mmtTuneTries = 3
+)
+
+// MMTFrequenciesUS are the frequencies North American pumps may be on
+var MMTFrequenciesUS = mmtFrequencies(916450000, 916800000)
+
+// MMTFrequenciesWorldwide are the frequencies pumps sold elsewhere may be on
+var MMTFrequenciesWorldwide = mmtFrequencies(868250000, 868650000)
+
+// mmtFrequencies lists the frequencies from low to high in mmtTuneStep
+func mmtFrequencies(low uint32, high uint32) []uint32 {
+	var frequencies []uint32
+	for freq := low; freq <= high; freq += mmtTuneStep {
+		frequencies = append(frequencies, freq)
+	}
+	return frequencies
+}
+
+// MMTTuneResult is how well the pump was heard on one frequency
+type MMTTuneResult struct {
+	Frequency uint32
+	// Replies is how many of the packets sent the pump answered
+	Replies int
+	// RSSI is the average of the replies, in dBm
+	RSSI int
+}
+
+// probe sends the pump a few packets and returns how many it answered and
+// how strongly on average
+func (mps *MMTPumpSession) probe() (int, int) {
+	packet := append(Encode4b6b(NewCarelinkShortMessage(CMTGetPumpModel).Packet(mps.pumpID)), 0x00)
+	replies, total := 0, 0
+	for try := 0; try < mmtTuneTries; try++ {
+		response, err := mps.rileylink.SendAndListen(RLPCPump, packet, 0, 0, RLPCPump, mmtListenTimeout, 0, 0)
+		if err != nil || response.Result != RLRSuccess || len(response.Payload) < 2 {
+			continue
+		}
+		pumpID, _, err := ParseCarelinkPacket(Decode4b6b(response.Payload[2:]))
+		if err != nil || !bytes.Equal(pumpID, mps.pumpID) {
+			continue
+		}
+		replies++
+		total += decodeCCRSSI(response.Payload[0])
+	}
+	if replies == 0 {
+		return 0, 0
+	}
+	return replies, total / replies
+}
+
+// Tune scans the frequencies for the one the pump is heard most strongly
+// on, and leaves the radio tuned to it; a lost reply says little about a
+// frequency, so how often the pump answered only breaks ties.  The radio
+// must be a TunableRadio, and the pump awake; if it isn't heard on any
+// frequency, the radio is put back where it was
+func (mps *MMTPumpSession) Tune(frequencies []uint32) ([]MMTTuneResult, error) {
+	radio, ok := mps.rileylink.(TunableRadio)
+	if !ok {
+		return nil, fmt.Errorf("radio can't be tuned")
+	}
+	original, err := radio.GetFrequency()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		results []MMTTuneResult
+		best    = -1
+	)
+	for _, freq := range frequencies {
+		err = radio.SetFrequency(freq)
+		if err != nil {
+			return results, err
+		}
+		result := MMTTuneResult{Frequency: freq}
+		result.Replies, result.RSSI = mps.probe()
+		log.WithFields(log.Fields{
+			"frequency": freq,
+			"replies":   result.Replies,
+			"rssi":      result.RSSI,
+		}).Debug("Tune")
+		results = append(results, result)
+		if result.Replies > 0 && (best < 0 || result.RSSI > results[best].RSSI ||
+			(result.RSSI == results[best].RSSI && result.Replies > results[best].Replies)) {
+			best = len(results) - 1
+		}
+	}
+	if best < 0 {
+		err = radio.SetFrequency(original)
+		if err != nil {
+			return results, err
+		}
+		return results, fmt.Errorf("pump not heard on any frequency")
+	}
+	log.WithFields(log.Fields{
+		"frequency": results[best].Frequency,
+		"rssi":      results[best].RSSI,
+	}).Info("tuned to pump")
+	return results, radio.SetFrequency(results[best].Frequency)
+}
@@ -0,0 +1,222 @@
+// mmtwatch.go contains the settings change counter, capture events, and
+// watching the pump for settings changes
+
+package gorileylink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReadSettingsChangeCounter returns a counter the pump bumps whenever
+// certain settings are changed; it wraps around
+func (mps *MMTPumpSession) ReadSettingsChangeCounter() (byte, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return 0, err
+	} else if !mps.Pump.Supports(CMTSettingsChangeCounter) {
+		return 0, fmt.Errorf("pump model %d has no settings change counter", mps.Pump.ModelNumber)
+	}
+	body, err := mps.readCommand(CMTSettingsChangeCounter)
+	if err != nil {
+		return 0, err
+	} else if len(body) < 4 {
+		return 0, fmt.Errorf("short settings change counter reply: %x", body)
+	}
+	return body[3], nil
+}
+
+// ReadCaptureEventEnabled returns whether the pump's capture event
+// feature is turned on
+func (mps *MMTPumpSession) ReadCaptureEventEnabled() (bool, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return false, err
+	} else if !mps.Pump.Supports(CMTReadCaptureEventEnabled) {
+		return false, fmt.Errorf("pump model %d has no capture events", mps.Pump.ModelNumber)
+	}
+	body, err := mps.readCommand(CMTReadCaptureEventEnabled)
+	if err != nil {
+		return false, err
+	} else if len(body) < 2 {
+		return false, fmt.Errorf("short capture event reply: %x", body)
+	}
+	return body[1] == 0x01, nil
+}
+
+// SetCaptureEventEnabled turns the capture event feature on or off, and
+// reads it back
+func (mps *MMTPumpSession) SetCaptureEventEnabled(enabled bool) error {
+	err := mps.ensureModel()
+	if err != nil {
+		return err
+	} else if !mps.Pump.Supports(CMTChangeCaptureEventEnable) {
+		return fmt.Errorf("pump model %d has no capture events", mps.Pump.ModelNumber)
+	}
+	param := byte(0x00)
+	if enabled {
+		param = 0x01
+	}
+	err = mps.setCommand(CMTChangeCaptureEventEnable, []byte{param})
+	if err != nil {
+		return err
+	}
+	now, err := mps.ReadCaptureEventEnabled()
+	if err != nil {
+		return err
+	} else if now != enabled {
+		return fmt.Errorf("capture event enable reads back as %v", now)
+	}
+	return nil
+}
+
+// MMTSettingsChange is a change of pump settings seen by the watcher
+type MMTSettingsChange struct {
+	Counter  byte
+	Previous *PumpSettings
+	Current  *PumpSettings
+	// Fields names the PumpSettings fields that differ
+	Fields []string
+}
+
+// diffPumpSettings names the fields that differ between two settings
+func diffPumpSettings(a *PumpSettings, b *PumpSettings) []string {
+	var fields []string
+	if a.MaxBasal != b.MaxBasal {
+		fields = append(fields, "MaxBasal")
+	}
+	if a.MaxBolus != b.MaxBolus {
+		fields = append(fields, "MaxBolus")
+	}
+	if a.InsulinActionCurve != b.InsulinActionCurve {
+		fields = append(fields, "InsulinActionCurve")
+	}
+	if a.ActiveBasalProfile != b.ActiveBasalProfile {
+		fields = append(fields, "ActiveBasalProfile")
+	}
+	if a.TempBasalType != b.TempBasalType {
+		fields = append(fields, "TempBasalType")
+	}
+	if a.TempBasalPercent != b.TempBasalPercent {
+		fields = append(fields, "TempBasalPercent")
+	}
+	if a.Alarm != b.Alarm {
+		fields = append(fields, "Alarm")
+	}
+	if a.AutoOff != b.AutoOff {
+		fields = append(fields, "AutoOff")
+	}
+	if a.PatternsEnabled != b.PatternsEnabled {
+		fields = append(fields, "PatternsEnabled")
+	}
+	return fields
+}
+
+// how many polls in a row may fail before a watcher gives up
+const mmtWatchMaxFailures = 3
+
+// MMTSettingsWatcher polls the settings change counter, and reads the
+// settings again whenever it moves
+type MMTSettingsWatcher struct {
+	session *MMTPumpSession
+	// Changes receives every change seen; it is closed when Watch returns
+	Changes chan MMTSettingsChange
+	// MaxFailures is how many polls in a row may fail, say with the pump
+	// out of range, before Watch gives up
+	MaxFailures int
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewMMTSettingsWatcher creates a watcher on a pump session
+func NewMMTSettingsWatcher(mps *MMTPumpSession) *MMTSettingsWatcher {
+	return &MMTSettingsWatcher{
+		session:     mps,
+		Changes:     make(chan MMTSettingsChange),
+		MaxFailures: mmtWatchMaxFailures,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Watch polls every interval until Stop is called.  A poll that fails is
+// logged and tried again on the next tick; Watch only gives up after
+// MaxFailures failures in a row, or at once if the pump refuses.  A
+// counter that moves without any decoded setting changing is still
+// reported, with no Fields
+func (msw *MMTSettingsWatcher) Watch(interval time.Duration) error {
+	defer close(msw.Changes)
+	pump := msw.session.Pump
+	if pump.ModelNumber != 0 && !pump.Supports(CMTSettingsChangeCounter) {
+		return fmt.Errorf("pump model %d has no settings change counter", pump.ModelNumber)
+	}
+	var (
+		counter  byte
+		settings *PumpSettings
+		failures int
+	)
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-msw.stop:
+				return nil
+			case <-time.After(interval):
+			}
+		}
+		now, current, err := msw.poll(counter, settings)
+		if _, refused := err.(*MMTPumpError); refused {
+			return err
+		} else if err != nil {
+			failures++
+			log.WithFields(log.Fields{
+				"failures": failures,
+				"err":      err,
+			}).Warn("settings watch poll failed")
+			if failures >= msw.MaxFailures {
+				return fmt.Errorf("settings watch gave up after %d failed polls: %v", failures, err)
+			}
+			continue
+		}
+		failures = 0
+		if settings == nil {
+			counter, settings = now, current
+			continue
+		} else if now == counter {
+			continue
+		}
+		change := MMTSettingsChange{now, settings, current, diffPumpSettings(settings, current)}
+		log.WithFields(log.Fields{
+			"counter": now,
+			"fields":  change.Fields,
+		}).Debug("settings changed")
+		counter, settings = now, current
+		select {
+		case msw.Changes <- change:
+		case <-msw.stop:
+			return nil
+		}
+	}
+}
+
+// poll reads the change counter, and the settings as well if the counter
+// moved or there are none yet
+func (msw *MMTSettingsWatcher) poll(counter byte, settings *PumpSettings) (byte, *PumpSettings, error) {
+	now, err := msw.session.ReadSettingsChangeCounter()
+	if err != nil {
+		return 0, nil, err
+	} else if settings != nil && now == counter {
+		return now, settings, nil
+	}
+	current, err := msw.session.ReadSettings()
+	if err != nil {
+		return 0, nil, err
+	}
+	return now, current, nil
+}
+
+// Stop makes Watch return; it may be called more than once
+func (msw *MMTSettingsWatcher) Stop() {
+	msw.stopOnce.Do(func() { close(msw.stop) })
+}
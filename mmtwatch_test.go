@@ -0,0 +1,155 @@
+// mmtwatch_test.go contains tests of watching the pump for settings
+// changes
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// settingsRadio is a PacketRadio standing in for an awake 554 that
+// answers only what a settings watcher asks: its settings, as captured,
+// and their change counter.  It loses a number of exchanges, as if the
+// pump were out of range
+type settingsRadio struct {
+	sync.Mutex
+	pumpID   []byte
+	counter  byte
+	settings []byte
+	lose     int32
+}
+
+func newSettingsRadio(t *testing.T) *settingsRadio {
+	t.Helper()
+	pumpID, settings, err := ParseCarelinkPacket(mustDecodeHex(t, settingsCaptureModern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &settingsRadio{pumpID: pumpID, settings: settings.Data}
+}
+
+// change sets the max bolus, in strokes of 0.1 U, as if on the pump itself
+func (sr *settingsRadio) change(maxBolus byte) {
+	sr.Lock()
+	defer sr.Unlock()
+	sr.settings[7] = maxBolus
+	sr.counter++
+}
+
+func (sr *settingsRadio) GetPacket(rlpc RileyLinkPacketChannel, timeout time.Duration) (*RLCCResponse, error) {
+	return &RLCCResponse{Result: RLRRecvTimeout}, nil
+}
+
+func (sr *settingsRadio) SendPacket(rlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, preamble time.Duration) error {
+	return nil
+}
+
+func (sr *settingsRadio) SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error) {
+	if atomic.AddInt32(&sr.lose, -1) >= 0 {
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	atomic.StoreInt32(&sr.lose, 0)
+	_, msg, err := ParseCarelinkPacket(Decode4b6b(packet[:len(packet)-1]))
+	if err != nil {
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	sr.Lock()
+	defer sr.Unlock()
+	var reply *CarelinkMessage
+	switch msg.MessageType {
+	case CMTGetPumpModel:
+		reply = NewCarelinkParamMessage(CMTGetPumpModel, []byte{0x03, '5', '5', '4'})
+	case CMTSettingsChangeCounter:
+		reply = NewCarelinkParamMessage(CMTSettingsChangeCounter, []byte{0x00, 0x00, sr.counter})
+	case CMTReadSettings:
+		reply = &CarelinkMessage{CMTReadSettings, append([]byte{}, sr.settings...)}
+	default:
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	payload := append([]byte{0x00, 0x01}, Encode4b6b(reply.Packet(sr.pumpID))...)
+	return &RLCCResponse{Result: RLRSuccess, Payload: append(payload, 0x00)}, nil
+}
+
+// newSettingsSession sets up a session with a settingsRadio
+func newSettingsSession(t *testing.T) (*MMTPumpSession, *settingsRadio) {
+	t.Helper()
+	radio := newSettingsRadio(t)
+	mps, err := NewMMTPumpSession(radio, &MedtronicPump{ModelNumber: 554}, hex.EncodeToString(radio.pumpID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mps, radio
+}
+
+func TestSettingsWatcher(t *testing.T) {
+	mps, radio := newSettingsSession(t)
+	// the first polls are lost, which the watcher rides out
+	atomic.StoreInt32(&radio.lose, 4)
+	watcher := NewMMTSettingsWatcher(mps)
+	done := make(chan error)
+	go func() {
+		done <- watcher.Watch(10 * time.Millisecond)
+	}()
+
+	// changed behind the watcher's back, as if on the pump itself
+	time.Sleep(100 * time.Millisecond)
+	radio.change(125)
+	select {
+	case change := <-watcher.Changes:
+		if change.Current.MaxBolus != 12.5 || len(change.Fields) != 1 || change.Fields[0] != "MaxBolus" {
+			t.Errorf("change %+v", change)
+		}
+	case err := <-done:
+		t.Fatalf("watch ended: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change seen")
+	}
+
+	// a pump that stays out of range is given up on
+	atomic.StoreInt32(&radio.lose, 1<<30)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("watch ended without an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch didn't give up")
+	}
+	if _, open := <-watcher.Changes; open {
+		t.Error("changes not closed")
+	}
+}
+
+func TestSettingsWatcherStop(t *testing.T) {
+	mps, _ := newSettingsSession(t)
+	watcher := NewMMTSettingsWatcher(mps)
+	done := make(chan error)
+	go func() {
+		done <- watcher.Watch(10 * time.Millisecond)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	watcher.Stop()
+	watcher.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch didn't stop")
+	}
+}
+
+func TestSettingsWatcherUnsupported(t *testing.T) {
+	mps, err := NewMMTPumpSession(&sendingRadio{}, &MedtronicPump{ModelNumber: 522}, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NewMMTSettingsWatcher(mps).Watch(time.Millisecond) == nil {
+		t.Error("watching a 522")
+	}
+}
@@ -0,0 +1,324 @@
+// mmtwizard.go contains the bolus wizard's carb ratio, insulin
+// sensitivity and BG target schedules
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// MMTBGUnits is how the pump displays blood glucose
+type MMTBGUnits byte
+
+const (
+	MMTBGUnitsMgDL  MMTBGUnits = 0x01
+	MMTBGUnitsMmolL MMTBGUnits = 0x02
+)
+
+func (mbu MMTBGUnits) String() string {
+	switch mbu {
+	case MMTBGUnitsMgDL:
+		return "MMTBGUnitsMgDL"
+	case MMTBGUnitsMmolL:
+		return "MMTBGUnitsMmolL"
+	default:
+		return "MMTBGUnitsUNKNOWN"
+	}
+}
+
+// the conversion the pump itself uses between mmol/L and mg/dL
+const mmtMgDLPerMmolL = 18
+
+// MgDL converts a value in these units to mg/dL
+func (mbu MMTBGUnits) MgDL(value float64) float64 {
+	if mbu == MMTBGUnitsMmolL {
+		return value * mmtMgDLPerMmolL
+	}
+	return value
+}
+
+// decode scales a raw BG value; mmol/L are kept in tenths
+func (mbu MMTBGUnits) decode(raw int) float64 {
+	if mbu == MMTBGUnitsMmolL {
+		return float64(raw) / 10
+	}
+	return float64(raw)
+}
+
+// MMTCarbUnits is how the pump counts carbohydrates
+type MMTCarbUnits byte
+
+const (
+	MMTCarbUnitsGrams     MMTCarbUnits = 0x01
+	MMTCarbUnitsExchanges MMTCarbUnits = 0x02
+)
+
+func (mcu MMTCarbUnits) String() string {
+	switch mcu {
+	case MMTCarbUnitsGrams:
+		return "MMTCarbUnitsGrams"
+	case MMTCarbUnitsExchanges:
+		return "MMTCarbUnitsExchanges"
+	default:
+		return "MMTCarbUnitsUNKNOWN"
+	}
+}
+
+// CarbRatioEntry is a carb ratio that starts at a time of day
+type CarbRatioEntry struct {
+	// Start is the time since midnight, in 30 minute steps
+	Start time.Duration
+	// Ratio is in g/U, or U/exchange when counting exchanges
+	Ratio float64
+}
+
+// CarbRatioSchedule is a day's carb ratios, ordered by start time
+type CarbRatioSchedule []CarbRatioEntry
+
+// At returns the ratio in effect at a time of day
+func (crs CarbRatioSchedule) At(sinceMidnight time.Duration) float64 {
+	ratio := 0.0
+	for _, entry := range crs {
+		if entry.Start > sinceMidnight {
+			break
+		}
+		ratio = entry.Ratio
+	}
+	return ratio
+}
+
+// MMTCarbRatios is the carb ratio schedule and the units it is kept in
+type MMTCarbRatios struct {
+	Units    MMTCarbUnits
+	Schedule CarbRatioSchedule
+}
+
+// SensitivityEntry is an insulin sensitivity that starts at a time of day
+type SensitivityEntry struct {
+	// Start is the time since midnight, in 30 minute steps
+	Start time.Duration
+	// Sensitivity is the BG drop per U, in the schedule's units
+	Sensitivity float64
+}
+
+// SensitivitySchedule is a day's insulin sensitivities, ordered by start
+type SensitivitySchedule []SensitivityEntry
+
+// At returns the sensitivity in effect at a time of day
+func (ss SensitivitySchedule) At(sinceMidnight time.Duration) float64 {
+	sensitivity := 0.0
+	for _, entry := range ss {
+		if entry.Start > sinceMidnight {
+			break
+		}
+		sensitivity = entry.Sensitivity
+	}
+	return sensitivity
+}
+
+// MMTSensitivities is the sensitivity schedule and the units it is kept in
+type MMTSensitivities struct {
+	Units    MMTBGUnits
+	Schedule SensitivitySchedule
+}
+
+// BGTargetEntry is a BG target range that starts at a time of day
+type BGTargetEntry struct {
+	// Start is the time since midnight, in 30 minute steps
+	Start time.Duration
+	// Low and High are in the schedule's units
+	Low  float64
+	High float64
+}
+
+// BGTargetSchedule is a day's BG targets, ordered by start time
+type BGTargetSchedule []BGTargetEntry
+
+// At returns the target range in effect at a time of day
+func (bts BGTargetSchedule) At(sinceMidnight time.Duration) (float64, float64) {
+	var low, high float64
+	for _, entry := range bts {
+		if entry.Start > sinceMidnight {
+			break
+		}
+		low, high = entry.Low, entry.High
+	}
+	return low, high
+}
+
+// MMTBGTargets is the BG target schedule and the units it is kept in
+type MMTBGTargets struct {
+	Units    MMTBGUnits
+	Schedule BGTargetSchedule
+}
+
+// wizardEntries splits the entries of a wizard schedule reply (length,
+// units, entries...) of the given size.  Every entry starts with its
+// half-hour slot in the low 6 bits; the schedule ends where they stop
+// increasing
+func wizardEntries(body []byte, size int) ([][]byte, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("short wizard schedule reply: %x", body)
+	}
+	end := len(body)
+	if length := int(body[0]) + 1; length >= 2 && length < end {
+		end = length
+	}
+	var entries [][]byte
+	for i := 2; i+size <= end; i += size {
+		slot := body[i] & 0x3f
+		if len(entries) > 0 && slot <= entries[len(entries)-1][0]&0x3f {
+			break
+		} else if slot >= mmtBasalScheduleSlots {
+			break
+		}
+		entries = append(entries, body[i:i+size])
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("wizard schedule has no entries: %x", body)
+	}
+	return entries, nil
+}
+
+// wizardStart returns when an entry starts from its slot
+func wizardStart(entry []byte) time.Duration {
+	return time.Duration(entry[0]&0x3f) * mmtBasalSlotDuration
+}
+
+// decodeCarbRatios unpacks carb ratios.  Modern pumps keep two bytes in
+// tenths of a gram or thousandths of a unit, older ones a byte of whole
+// grams or tenths of a unit
+func decodeCarbRatios(body []byte, pump *MedtronicPump) (*MMTCarbRatios, error) {
+	size := 2
+	if pump.Modern() {
+		size = 3
+	}
+	entries, err := wizardEntries(body, size)
+	if err != nil {
+		return nil, err
+	}
+	ratios := &MMTCarbRatios{Units: MMTCarbUnits(body[1])}
+	for _, entry := range entries {
+		var ratio float64
+		if pump.Modern() {
+			ratio = float64(binary.BigEndian.Uint16(entry[1:3]))
+			if ratios.Units == MMTCarbUnitsExchanges {
+				ratio /= 1000
+			} else {
+				ratio /= 10
+			}
+		} else {
+			ratio = float64(entry[1])
+			if ratios.Units == MMTCarbUnitsExchanges {
+				ratio /= 10
+			}
+		}
+		ratios.Schedule = append(ratios.Schedule, CarbRatioEntry{wizardStart(entry), ratio})
+	}
+	return ratios, nil
+}
+
+// decodeSensitivities unpacks insulin sensitivities, whose value has a
+// ninth bit in the slot byte
+func decodeSensitivities(body []byte) (*MMTSensitivities, error) {
+	entries, err := wizardEntries(body, 2)
+	if err != nil {
+		return nil, err
+	}
+	sensitivities := &MMTSensitivities{Units: MMTBGUnits(body[1])}
+	for _, entry := range entries {
+		raw := int(entry[0]&0x40)<<2 | int(entry[1])
+		sensitivities.Schedule = append(sensitivities.Schedule,
+			SensitivityEntry{wizardStart(entry), sensitivities.Units.decode(raw)})
+	}
+	return sensitivities, nil
+}
+
+// decodeBGTargets unpacks BG targets.  Pumps from the x15 on keep ranges
+// of slot, low and high; older ones a single target per slot, which is
+// taken as a range of one value
+func decodeBGTargets(body []byte, pump *MedtronicPump) (*MMTBGTargets, error) {
+	ranges := pump.Supports(CMTReadBGTargets515)
+	size := 2
+	if ranges {
+		size = 3
+	}
+	entries, err := wizardEntries(body, size)
+	if err != nil {
+		return nil, err
+	}
+	targets := &MMTBGTargets{Units: MMTBGUnits(body[1])}
+	for _, entry := range entries {
+		low := targets.Units.decode(int(entry[1]))
+		high := low
+		if ranges {
+			high = targets.Units.decode(int(entry[2]))
+		}
+		targets.Schedule = append(targets.Schedule, BGTargetEntry{wizardStart(entry), low, high})
+	}
+	return targets, nil
+}
+
+// ReadBGUnits returns how the pump displays blood glucose
+func (mps *MMTPumpSession) ReadBGUnits() (MMTBGUnits, error) {
+	body, err := mps.readCommand(CMTReadBGUnits)
+	if err != nil {
+		return 0, err
+	} else if len(body) < 2 {
+		return 0, fmt.Errorf("short BG units reply: %x", body)
+	}
+	return MMTBGUnits(body[1]), nil
+}
+
+// ReadCarbUnits returns how the pump counts carbohydrates
+func (mps *MMTPumpSession) ReadCarbUnits() (MMTCarbUnits, error) {
+	body, err := mps.readCommand(CMTReadCarbUnits)
+	if err != nil {
+		return 0, err
+	} else if len(body) < 2 {
+		return 0, fmt.Errorf("short carb units reply: %x", body)
+	}
+	return MMTCarbUnits(body[1]), nil
+}
+
+// ReadCarbRatios returns the bolus wizard's carb ratio schedule
+func (mps *MMTPumpSession) ReadCarbRatios() (*MMTCarbRatios, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	}
+	body, err := mps.readCommand(CMTReadCarbRatios)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCarbRatios(body, mps.Pump)
+}
+
+// ReadSensitivities returns the bolus wizard's insulin sensitivity schedule
+func (mps *MMTPumpSession) ReadSensitivities() (*MMTSensitivities, error) {
+	body, err := mps.readCommand(CMTReadSensitivities)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSensitivities(body)
+}
+
+// ReadBGTargets returns the bolus wizard's BG target schedule
+func (mps *MMTPumpSession) ReadBGTargets() (*MMTBGTargets, error) {
+	err := mps.ensureModel()
+	if err != nil {
+		return nil, err
+	}
+	// newer pumps moved the targets to a command of their own
+	cmt := CMTReadBGTargets
+	if mps.Pump.Supports(CMTReadBGTargets515) {
+		cmt = CMTReadBGTargets515
+	}
+	body, err := mps.readCommand(cmt)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBGTargets(body, mps.Pump)
+}
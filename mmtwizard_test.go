@@ -0,0 +1,116 @@
+// mmtwizard_test.go contains tests of decoding the bolus wizard's
+// schedules
+
+package gorileylink
+
+import (
+	"testing"
+	"time"
+)
+
+// the wizard schedule replies below are laid out as MinimedKit's
+// ReadCarbRatios, ReadInsulinSensitivities and ReadBGTargets message
+// bodies read them: length, units, then the entries, zero-padded
+
+func TestDecodeCarbRatios(t *testing.T) {
+	for _, tc := range []struct {
+		model int
+		body  string
+		units MMTCarbUnits
+		want  CarbRatioSchedule
+	}{
+		// a byte of whole grams before the x23
+		{522, "0501000f100c0000", MMTCarbUnitsGrams,
+			CarbRatioSchedule{{0, 15}, {8 * time.Hour, 12}}},
+		// tenths of a unit per exchange
+		{522, "0302000f0000", MMTCarbUnitsExchanges,
+			CarbRatioSchedule{{0, 1.5}}},
+		// two bytes of tenths of a gram from the x23 on
+		{554, "070100009610007800", MMTCarbUnitsGrams,
+			CarbRatioSchedule{{0, 15}, {8 * time.Hour, 12}}},
+		// thousandths of a unit per exchange
+		{554, "04020005dc0000", MMTCarbUnitsExchanges,
+			CarbRatioSchedule{{0, 1.5}}},
+	} {
+		ratios, err := decodeCarbRatios(mustDecodeHex(t, tc.body), &MedtronicPump{ModelNumber: tc.model})
+		if err != nil {
+			t.Errorf("%d %s: %v", tc.model, tc.body, err)
+			continue
+		}
+		if ratios.Units != tc.units || len(ratios.Schedule) != len(tc.want) {
+			t.Errorf("%d %s: %v %v", tc.model, tc.body, ratios.Units, ratios.Schedule)
+			continue
+		}
+		for i, entry := range tc.want {
+			if ratios.Schedule[i] != entry {
+				t.Errorf("%d %s: entry %d %+v, want %+v", tc.model, tc.body, i, ratios.Schedule[i], entry)
+			}
+		}
+	}
+}
+
+func TestDecodeSensitivities(t *testing.T) {
+	for _, tc := range []struct {
+		body  string
+		units MMTBGUnits
+		want  SensitivitySchedule
+	}{
+		{"050100320c2d0000", MMTBGUnitsMgDL,
+			SensitivitySchedule{{0, 50}, {6 * time.Hour, 45}}},
+		// the ninth bit is in the slot byte
+		{"0301402c0000", MMTBGUnitsMgDL,
+			SensitivitySchedule{{0, 300}}},
+		{"030200190000", MMTBGUnitsMmolL,
+			SensitivitySchedule{{0, 2.5}}},
+	} {
+		sensitivities, err := decodeSensitivities(mustDecodeHex(t, tc.body))
+		if err != nil {
+			t.Errorf("%s: %v", tc.body, err)
+			continue
+		}
+		if sensitivities.Units != tc.units || len(sensitivities.Schedule) != len(tc.want) {
+			t.Errorf("%s: %v %v", tc.body, sensitivities.Units, sensitivities.Schedule)
+			continue
+		}
+		for i, entry := range tc.want {
+			if sensitivities.Schedule[i] != entry {
+				t.Errorf("%s: entry %d %+v, want %+v", tc.body, i, sensitivities.Schedule[i], entry)
+			}
+		}
+	}
+}
+
+func TestDecodeBGTargets(t *testing.T) {
+	for _, tc := range []struct {
+		model int
+		body  string
+		units MMTBGUnits
+		want  BGTargetSchedule
+	}{
+		// a single target per slot before the x15, read with 0x8c
+		{512, "05010064106e0000", MMTBGUnitsMgDL,
+			BGTargetSchedule{{0, 100, 100}, {8 * time.Hour, 110, 110}}},
+		{512, "030200370000", MMTBGUnitsMmolL,
+			BGTargetSchedule{{0, 5.5, 5.5}}},
+		// ranges from the x15 on, read with 0x9f
+		{522, "0701005a7810648c0000", MMTBGUnitsMgDL,
+			BGTargetSchedule{{0, 90, 120}, {8 * time.Hour, 100, 140}}},
+		{554, "040200375000", MMTBGUnitsMmolL,
+			BGTargetSchedule{{0, 5.5, 8}}},
+	} {
+		targets, err := decodeBGTargets(mustDecodeHex(t, tc.body), &MedtronicPump{ModelNumber: tc.model})
+		if err != nil {
+			t.Errorf("%d %s: %v", tc.model, tc.body, err)
+			continue
+		}
+		if targets.Units != tc.units || len(targets.Schedule) != len(tc.want) {
+			t.Errorf("%d %s: %v %v", tc.model, tc.body, targets.Units, targets.Schedule)
+			continue
+		}
+		for i, entry := range tc.want {
+			if targets.Schedule[i] != entry {
+				t.Errorf("%d %s: entry %d %+v, want %+v", tc.model, tc.body, i, targets.Schedule[i], entry)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// mysentry.go contains listening to the status a pump broadcasts to a
+// paired MySentry monitor
+
+package gorileylink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MySentryTrend is the sensor glucose trend arrows
+type MySentryTrend byte
+
+const (
+	MySentryTrendFlat     MySentryTrend = 0x00
+	MySentryTrendUp       MySentryTrend = 0x01
+	MySentryTrendUpUp     MySentryTrend = 0x02
+	MySentryTrendDown     MySentryTrend = 0x03
+	MySentryTrendDownDown MySentryTrend = 0x04
+)
+
+func (mst MySentryTrend) String() string {
+	switch mst {
+	case MySentryTrendFlat:
+		return "MySentryTrendFlat"
+	case MySentryTrendUp:
+		return "MySentryTrendUp"
+	case MySentryTrendUpUp:
+		return "MySentryTrendUpUp"
+	case MySentryTrendDown:
+		return "MySentryTrendDown"
+	case MySentryTrendDownDown:
+		return "MySentryTrendDownDown"
+	default:
+		return "MySentryTrendUNKNOWN"
+	}
+}
+
+// MySentryEvent is a status or alert broadcast by the pump
+type MySentryEvent interface {
+	PacketSequence() byte
+}
+
+// MySentryStatus is the periodic pump status broadcast
+type MySentryStatus struct {
+	Sequence byte
+	PumpTime time.Time
+	Trend    MySentryTrend
+	// Glucose and PreviousGlucose are in mg/dL, zero without a reading
+	Glucose         int
+	PreviousGlucose int
+	// GlucoseTime is when the sensor reading was taken
+	GlucoseTime time.Time
+	// SensorAge is how long the sensor has been in, SensorRemaining how
+	// long until it must be replaced
+	SensorAge       time.Duration
+	SensorRemaining time.Duration
+	// IOB and ReservoirUnits are in U
+	IOB               float64
+	ReservoirUnits    float64
+	ReservoirPercent  int
+	ReservoirTimeLeft time.Duration
+	BatteryPercent    int
+	RSSI              int
+}
+
+// PacketSequence returns the sequence the broadcast is ACKed with
+func (mss *MySentryStatus) PacketSequence() byte {
+	return mss.Sequence
+}
+
+// MySentryAlert is an alert being raised or cleared on the pump
+type MySentryAlert struct {
+	Sequence  byte
+	AlertType byte
+	Cleared   bool
+	// Timestamp is when the alert was raised; zero when cleared
+	Timestamp time.Time
+	RSSI      int
+}
+
+// PacketSequence returns the sequence the broadcast is ACKed with
+func (msa *MySentryAlert) PacketSequence() byte {
+	return msa.Sequence
+}
+
+// decodeMySentryTime unpacks hour, minute, second, year, month, day
+func decodeMySentryTime(data []byte, loc *time.Location) time.Time {
+	month := time.Month(data[4] & 0x0f)
+	if month < time.January || month > time.December || data[5] < 1 || data[0] > 23 || data[1] > 59 || data[2] > 59 {
+		return time.Time{}
+	}
+	return time.Date(2000+int(data[3]), month, int(data[5]), int(data[0]), int(data[1]), int(data[2]), 0, loc)
+}
+
+// decodeMySentryStatus unpacks a CMTPumpStatus broadcast body.  Glucose
+// values are 9 bits, with their lowest bits sharing a byte
+func decodeMySentryStatus(data []byte, loc *time.Location) (*MySentryStatus, error) {
+	if len(data) < 34 {
+		return nil, fmt.Errorf("short MySentry status: %x", data)
+	}
+	return &MySentryStatus{
+		Sequence:          data[0],
+		Trend:             MySentryTrend((data[1] & 0x0e) >> 1),
+		PumpTime:          decodeMySentryTime(data[2:8], loc),
+		Glucose:           int(data[9])<<1 | int(data[24]&1),
+		PreviousGlucose:   int(data[10])<<1 | int(data[24]>>1&1),
+		ReservoirUnits:    float64(binary.BigEndian.Uint16(data[12:14])) / 40,
+		BatteryPercent:    int(data[14]) * 25,
+		ReservoirPercent:  int(data[15]) * 25,
+		ReservoirTimeLeft: time.Duration(binary.BigEndian.Uint16(data[16:18])) * time.Minute,
+		SensorAge:         time.Duration(data[18]) * time.Hour,
+		SensorRemaining:   time.Duration(data[19]) * time.Hour,
+		IOB:               float64(binary.BigEndian.Uint16(data[22:24])) / 40,
+		GlucoseTime:       decodeMySentryTime(data[28:34], loc),
+	}, nil
+}
+
+// decodeMySentryAlert unpacks a CMTAlert or CMTAlertCleared broadcast body
+func decodeMySentryAlert(msg *CarelinkMessage, loc *time.Location) (*MySentryAlert, error) {
+	if msg.MessageType == CMTAlertCleared {
+		if len(msg.Data) < 2 {
+			return nil, fmt.Errorf("short MySentry alert: %x", msg.Data)
+		}
+		return &MySentryAlert{Sequence: msg.Data[0], AlertType: msg.Data[1], Cleared: true}, nil
+	} else if len(msg.Data) < 8 {
+		return nil, fmt.Errorf("short MySentry alert: %x", msg.Data)
+	}
+	return &MySentryAlert{
+		Sequence:  msg.Data[0],
+		AlertType: msg.Data[1],
+		Timestamp: decodeMySentryTime(msg.Data[2:8], loc),
+	}, nil
+}
+
+// MySentryListener sits on the pump channel decoding and ACKing what the
+// pump broadcasts to its MySentry monitor, without ever waking the pump
+type MySentryListener struct {
+	rileylink  PacketRadio
+	pumpID     []byte
+	mySentryID []byte
+	// Location is the time zone the pump clock is kept in
+	Location *time.Location
+	// Events receives every new status and alert; it is closed when
+	// Listen returns
+	Events   chan MySentryEvent
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMySentryListener creates a listener for the pump of the given
+// serial, ACKing as the MySentry monitor with the given ID that the pump
+// is paired with
+func NewMySentryListener(crl PacketRadio, pumpID string, mySentryID string) (*MySentryListener, error) {
+	pid, err := ParsePumpID(pumpID)
+	if err != nil {
+		return nil, err
+	}
+	msid, err := ParsePumpID(mySentryID)
+	if err != nil {
+		return nil, err
+	}
+	return &MySentryListener{
+		rileylink:  crl,
+		pumpID:     pid,
+		mySentryID: msid,
+		Location:   time.Local,
+		Events:     make(chan MySentryEvent),
+		stop:       make(chan struct{}),
+	}, nil
+}
+
+// ack tells the pump a broadcast was received, so it stops repeating it;
+// the body is the sequence, the monitor's ID, then the type ACKed padded
+// out as MinimedKit's MySentryAckMessageBody lays it
+func (msl *MySentryListener) ack(sequence byte, mt CarelinkMessageType) error {
+	body := []byte{sequence}
+	body = append(body, msl.mySentryID...)
+	body = append(body, 0x00, byte(mt), 0x00, 0x00, 0x00)
+	ack := &CarelinkMessage{CMTPumpAck, body}
+	packet := append(Encode4b6b(ack.PacketAs(MMTPacketMySentry, msl.pumpID)), 0x00)
+	return msl.rileylink.SendPacket(RLPCPump, packet, 0, 0, 0)
+}
+
+// Listen receives broadcasts until Stop is called or the RileyLink fails,
+// polling in slices of timeout
+func (msl *MySentryListener) Listen(timeout time.Duration) error {
+	defer close(msl.Events)
+	var (
+		lastSequence byte
+		lastType     CarelinkMessageType
+	)
+	for {
+		select {
+		case <-msl.stop:
+			return nil
+		default:
+		}
+		response, err := msl.rileylink.GetPacket(RLPCPump, timeout)
+		if err != nil {
+			return err
+		} else if response.Result != RLRSuccess || len(response.Payload) < 2 {
+			continue
+		}
+		mpt, pumpID, msg, err := ParseMMTPacket(Decode4b6b(response.Payload[2:]))
+		if err != nil {
+			log.WithField("err", err).Debug("MySentry: undecodable packet")
+			continue
+		} else if mpt != MMTPacketMySentry || !bytes.Equal(pumpID, msl.pumpID) || len(msg.Data) < 1 {
+			continue
+		}
+		sequence := msg.Data[0]
+		err = msl.ack(sequence, msg.MessageType)
+		if err != nil {
+			return err
+		}
+		// the pump repeats a broadcast until it is ACKed
+		if sequence == lastSequence && msg.MessageType == lastType {
+			continue
+		}
+		lastSequence, lastType = sequence, msg.MessageType
+		rssi := decodeCCRSSI(response.Payload[0])
+		switch msg.MessageType {
+		case CMTPumpStatus:
+			status, err := decodeMySentryStatus(msg.Data, msl.Location)
+			if err != nil {
+				log.WithField("err", err).Debug("MySentry")
+				continue
+			}
+			status.RSSI = rssi
+			if !msl.emit(status) {
+				return nil
+			}
+		case CMTAlert, CMTAlertCleared:
+			alert, err := decodeMySentryAlert(msg, msl.Location)
+			if err != nil {
+				log.WithField("err", err).Debug("MySentry")
+				continue
+			}
+			alert.RSSI = rssi
+			if !msl.emit(alert) {
+				return nil
+			}
+		default:
+			log.WithField("type", msg.MessageType).Debug("MySentry: unknown message")
+		}
+	}
+}
+
+// emit hands an event over, unless the listener is stopped meanwhile
+func (msl *MySentryListener) emit(event MySentryEvent) bool {
+	select {
+	case msl.Events <- event:
+		return true
+	case <-msl.stop:
+		return false
+	}
+}
+
+// Stop makes Listen return after the current poll; it may be called more
+// than once
+func (msl *MySentryListener) Stop() {
+	msl.stopOnce.Do(func() { close(msl.stop) })
+}
@@ -0,0 +1,116 @@
+// mysentry_test.go contains tests of decoding MySentry broadcasts
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// a MySentry status broadcast captured from a pump, as used in Loop's
+// MySentryPumpStatusMessageBody tests
+const mySentryStatusCapture = "a2594040042f511727070f09050184850000cd010105b03e0a0a1a009d030000711726000f09050000d0"
+
+func TestDecodeMySentryStatus(t *testing.T) {
+	mpt, pumpID, msg, err := ParseMMTPacket(mustDecodeHex(t, mySentryStatusCapture))
+	if err != nil {
+		t.Fatal(err)
+	} else if mpt != MMTPacketMySentry || msg.MessageType != CMTPumpStatus {
+		t.Fatalf("captured a %v %v", mpt, msg.MessageType)
+	} else if string(pumpID) != "\x59\x40\x40" {
+		t.Fatalf("captured from %x", pumpID)
+	}
+	status, err := decodeMySentryStatus(msg.Data, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Sequence != 0x2f {
+		t.Errorf("sequence %#x", status.Sequence)
+	}
+	if status.Trend != MySentryTrendFlat {
+		t.Errorf("trend %v", status.Trend)
+	}
+	if want := time.Date(2015, 9, 5, 23, 39, 7, 0, time.UTC); !status.PumpTime.Equal(want) {
+		t.Errorf("pump time %v", status.PumpTime)
+	}
+	if status.Glucose != 265 || status.PreviousGlucose != 267 {
+		t.Errorf("glucose %d, previous %d", status.Glucose, status.PreviousGlucose)
+	}
+	if want := time.Date(2015, 9, 5, 23, 38, 0, 0, time.UTC); !status.GlucoseTime.Equal(want) {
+		t.Errorf("glucose time %v", status.GlucoseTime)
+	}
+	if status.ReservoirUnits != 5.125 || status.ReservoirPercent != 25 {
+		t.Errorf("reservoir %v U, %d%%", status.ReservoirUnits, status.ReservoirPercent)
+	}
+	if status.ReservoirTimeLeft != 1456*time.Minute {
+		t.Errorf("reservoir time left %v", status.ReservoirTimeLeft)
+	}
+	if status.BatteryPercent != 25 {
+		t.Errorf("battery %d%%", status.BatteryPercent)
+	}
+	if status.SensorAge != 62*time.Hour || status.SensorRemaining != 10*time.Hour {
+		t.Errorf("sensor age %v, remaining %v", status.SensorAge, status.SensorRemaining)
+	}
+	if status.IOB != 3.925 {
+		t.Errorf("IOB %v U", status.IOB)
+	}
+}
+
+func TestDecodeMySentryStatusShort(t *testing.T) {
+	_, err := decodeMySentryStatus(make([]byte, 33), time.UTC)
+	if err == nil {
+		t.Error("short status decoded")
+	}
+}
+
+// sendingRadio is a PacketRadio that hears nothing and keeps what it sends
+type sendingRadio struct {
+	sent [][]byte
+}
+
+func (sr *sendingRadio) GetPacket(rlpc RileyLinkPacketChannel, timeout time.Duration) (*RLCCResponse, error) {
+	return &RLCCResponse{Result: RLRRecvTimeout}, nil
+}
+
+func (sr *sendingRadio) SendPacket(rlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, preamble time.Duration) error {
+	sr.sent = append(sr.sent, packet)
+	return nil
+}
+
+func (sr *sendingRadio) SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error) {
+	sr.sent = append(sr.sent, packet)
+	return &RLCCResponse{Result: RLRRecvTimeout}, nil
+}
+
+func TestMySentryAck(t *testing.T) {
+	radio := &sendingRadio{}
+	msl, err := NewMySentryListener(radio, "350535", "000695")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = msl.ack(0x59, CMTPumpStatus)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(radio.sent) != 1 {
+		t.Fatalf("%d packets sent", len(radio.sent))
+	}
+	sent := radio.sent[0]
+	// a MySentry ACK captured from a monitor, as used in MinimedKit's
+	// MySentryAckMessageBody tests
+	if got := hex.EncodeToString(Decode4b6b(sent[:len(sent)-1])); got != "a235053506590006950004000000e2" {
+		t.Errorf("sent %s", got)
+	}
+}
+
+func TestMySentryStop(t *testing.T) {
+	msl, err := NewMySentryListener(&sendingRadio{}, "594040", "000695")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msl.Stop()
+	msl.Stop()
+	if err = msl.Listen(time.Millisecond); err != nil {
+		t.Error(err)
+	}
+}
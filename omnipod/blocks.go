@@ -0,0 +1,498 @@
+// blocks.go contains the command and response blocks messages are made of
+
+package omnipod
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// PulseSize is the insulin the pod delivers in one pulse, in U
+	PulseSize = 0.05
+	// PulsesPerUnit is the pulses in one U
+	PulsesPerUnit = 20
+	// the pod reports reservoirs above this many U only as "over"
+	MaxReservoirReading = 50
+	// status responses carry no length byte
+	statusResponseLength = 10
+	// the reservoir level reads this when above MaxReservoirReading
+	reservoirOverReading = 0x3ff
+)
+
+// BlockType is the first byte of a message block
+type BlockType byte
+
+const (
+	BlockVersionResponse    BlockType = 0x01
+	BlockPodInfoResponse    BlockType = 0x02
+	BlockErrorResponse      BlockType = 0x06
+	BlockGetStatus          BlockType = 0x0e
+	BlockBasalScheduleExtra BlockType = 0x13
+	BlockTempBasalExtra     BlockType = 0x16
+	BlockBolusExtra         BlockType = 0x17
+	BlockSetInsulinSchedule BlockType = 0x1a
+	BlockDeactivatePod      BlockType = 0x1c
+	BlockStatusResponse     BlockType = 0x1d
+	BlockCancelDelivery     BlockType = 0x1f
+)
+
+func (bt BlockType) String() string {
+	switch bt {
+	case BlockVersionResponse:
+		return "BlockVersionResponse"
+	case BlockPodInfoResponse:
+		return "BlockPodInfoResponse"
+	case BlockErrorResponse:
+		return "BlockErrorResponse"
+	case BlockGetStatus:
+		return "BlockGetStatus"
+	case BlockBasalScheduleExtra:
+		return "BlockBasalScheduleExtra"
+	case BlockTempBasalExtra:
+		return "BlockTempBasalExtra"
+	case BlockBolusExtra:
+		return "BlockBolusExtra"
+	case BlockSetInsulinSchedule:
+		return "BlockSetInsulinSchedule"
+	case BlockDeactivatePod:
+		return "BlockDeactivatePod"
+	case BlockStatusResponse:
+		return "BlockStatusResponse"
+	case BlockCancelDelivery:
+		return "BlockCancelDelivery"
+	default:
+		return "BlockUNKNOWN"
+	}
+}
+
+// MessageBlock is a command or response within a message
+type MessageBlock interface {
+	Type() BlockType
+	// Encode lays the block out, type byte first
+	Encode() []byte
+}
+
+// NonceBlock is a command the pod only accepts with the current nonce
+type NonceBlock interface {
+	MessageBlock
+	Nonce() uint32
+	SetNonce(nonce uint32)
+}
+
+// encodeBlock prefixes block data with its type and length
+func encodeBlock(bt BlockType, data []byte) []byte {
+	return append([]byte{byte(bt), byte(len(data))}, data...)
+}
+
+// encodeNonce lays out a nonce ahead of the rest of a block's data
+func encodeNonce(nonce uint32, data []byte) []byte {
+	encoded := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(encoded, nonce)
+	return append(encoded, data...)
+}
+
+// UnknownBlock is a block this package doesn't decode
+type UnknownBlock struct {
+	BlockType BlockType
+	Data      []byte
+}
+
+func (ub *UnknownBlock) Type() BlockType {
+	return ub.BlockType
+}
+
+func (ub *UnknownBlock) Encode() []byte {
+	return encodeBlock(ub.BlockType, ub.Data)
+}
+
+// DecodeBlocks unpacks a message body into its blocks
+func DecodeBlocks(body []byte) ([]MessageBlock, error) {
+	var blocks []MessageBlock
+	for len(body) > 0 {
+		bt := BlockType(body[0])
+		length := 0
+		if bt == BlockStatusResponse {
+			length = statusResponseLength
+		} else if len(body) >= 2 {
+			length = 2 + int(body[1])
+		}
+		if length == 0 || length > len(body) {
+			return nil, fmt.Errorf("short %v block: %x", bt, body)
+		}
+		block, err := decodeBlock(bt, body[:length])
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		body = body[length:]
+	}
+	return blocks, nil
+}
+
+// decodeBlock unpacks a whole block, type byte first
+func decodeBlock(bt BlockType, data []byte) (MessageBlock, error) {
+	switch bt {
+	case BlockStatusResponse:
+		return decodeStatusResponse(data), nil
+	case BlockErrorResponse:
+		return decodeErrorResponse(data)
+	case BlockPodInfoResponse:
+		return decodePodInfoResponse(data)
+	case BlockVersionResponse:
+		return decodeVersionResponse(data)
+	default:
+		return &UnknownBlock{bt, append([]byte(nil), data[2:]...)}, nil
+	}
+}
+
+// PodInfoType selects what a GetStatus command asks for
+type PodInfoType byte
+
+const (
+	// PodInfoNormal answers with a StatusResponse
+	PodInfoNormal PodInfoType = 0x00
+	// the rest answer with a PodInfoResponse
+	PodInfoConfiguredAlerts PodInfoType = 0x01
+	PodInfoFaultEvents      PodInfoType = 0x02
+	PodInfoDataLog          PodInfoType = 0x03
+	PodInfoFault            PodInfoType = 0x05
+	PodInfoPulseLogRecent   PodInfoType = 0x50
+	PodInfoPulseLogPrevious PodInfoType = 0x51
+)
+
+// GetStatusCommand asks the pod for its status or other information
+type GetStatusCommand struct {
+	InfoType PodInfoType
+}
+
+func (gsc *GetStatusCommand) Type() BlockType {
+	return BlockGetStatus
+}
+
+func (gsc *GetStatusCommand) Encode() []byte {
+	return encodeBlock(BlockGetStatus, []byte{byte(gsc.InfoType)})
+}
+
+// DeliveryStatus is a set of DeliveryType bits; none means suspended
+type DeliveryStatus byte
+
+// DeliveryType is a kind of insulin delivery, as reported and cancelled
+type DeliveryType byte
+
+const (
+	DeliveryBasal          DeliveryType = 0x01
+	DeliveryTempBasal      DeliveryType = 0x02
+	DeliveryBolus          DeliveryType = 0x04
+	DeliveryExtendedBolus  DeliveryType = 0x08
+	DeliveryAll            DeliveryType = 0x07
+	DeliveryAllAndExtended DeliveryType = 0x0f
+)
+
+// Suspended returns whether nothing is being delivered
+func (ds DeliveryStatus) Suspended() bool {
+	return ds == 0
+}
+
+// Delivering returns whether a kind of delivery is running
+func (ds DeliveryStatus) Delivering(dt DeliveryType) bool {
+	return byte(ds)&byte(dt) != 0
+}
+
+// PodProgress is how far along its life a pod is
+type PodProgress byte
+
+const (
+	PodProgressInitial           PodProgress = 0x00
+	PodProgressPairingSuccess    PodProgress = 0x03
+	PodProgressPriming           PodProgress = 0x04
+	PodProgressReadyForBasal     PodProgress = 0x05
+	PodProgressReadyForCannula   PodProgress = 0x06
+	PodProgressCannulaInserting  PodProgress = 0x07
+	PodProgressAboveFiftyUnits   PodProgress = 0x08
+	PodProgressFiftyOrLessUnits  PodProgress = 0x09
+	PodProgressFaultEventOccured PodProgress = 0x0d
+	PodProgressActivationTimeout PodProgress = 0x0e
+	PodProgressInactive          PodProgress = 0x0f
+)
+
+func (pp PodProgress) String() string {
+	switch pp {
+	case PodProgressInitial:
+		return "PodProgressInitial"
+	case PodProgressPairingSuccess:
+		return "PodProgressPairingSuccess"
+	case PodProgressPriming:
+		return "PodProgressPriming"
+	case PodProgressReadyForBasal:
+		return "PodProgressReadyForBasal"
+	case PodProgressReadyForCannula:
+		return "PodProgressReadyForCannula"
+	case PodProgressCannulaInserting:
+		return "PodProgressCannulaInserting"
+	case PodProgressAboveFiftyUnits:
+		return "PodProgressAboveFiftyUnits"
+	case PodProgressFiftyOrLessUnits:
+		return "PodProgressFiftyOrLessUnits"
+	case PodProgressFaultEventOccured:
+		return "PodProgressFaultEventOccured"
+	case PodProgressActivationTimeout:
+		return "PodProgressActivationTimeout"
+	case PodProgressInactive:
+		return "PodProgressInactive"
+	default:
+		return "PodProgressUNKNOWN"
+	}
+}
+
+// Running returns whether the pod is delivering insulin normally
+func (pp PodProgress) Running() bool {
+	return pp == PodProgressAboveFiftyUnits || pp == PodProgressFiftyOrLessUnits
+}
+
+// StatusResponse is the pod's answer to most commands
+type StatusResponse struct {
+	Delivery DeliveryStatus
+	Progress PodProgress
+	// Delivered is the insulin delivered since activation, in U
+	Delivered float64
+	// MessageCounter is the sequence number of the message last received
+	MessageCounter byte
+	// BolusNotDelivered is what remains of a bolus in progress, in U
+	BolusNotDelivered float64
+	// Alerts is a bit per alert slot that is active
+	Alerts byte
+	// Active is the time since activation, to the minute
+	Active time.Duration
+	// Reservoir is in U, or MaxReservoirReading when ReservoirOver
+	Reservoir     float64
+	ReservoirOver bool
+}
+
+func (sr *StatusResponse) Type() BlockType {
+	return BlockStatusResponse
+}
+
+func (sr *StatusResponse) Encode() []byte {
+	data := make([]byte, statusResponseLength)
+	data[0] = byte(BlockStatusResponse)
+	data[1] = byte(sr.Delivery)<<4 | byte(sr.Progress)&0x0f
+	delivered := int(math.Round(sr.Delivered * PulsesPerUnit))
+	notDelivered := int(math.Round(sr.BolusNotDelivered * PulsesPerUnit))
+	data[2] = byte(delivered>>9) & 0x0f
+	data[3] = byte(delivered >> 1)
+	data[4] = byte(delivered&0x01)<<7 | (sr.MessageCounter&0x0f)<<3 | byte(notDelivered>>8)&0x03
+	data[5] = byte(notDelivered)
+	minutes := int(sr.Active / time.Minute)
+	data[6] = sr.Alerts >> 1
+	data[7] = sr.Alerts<<7 | byte(minutes>>6)&0x7f
+	reservoir := int(math.Round(sr.Reservoir * PulsesPerUnit))
+	if sr.ReservoirOver {
+		reservoir = reservoirOverReading
+	}
+	data[8] = byte(minutes<<2) | byte(reservoir>>8)&0x03
+	data[9] = byte(reservoir)
+	return data
+}
+
+// decodeStatusResponse unpacks the fixed-length status block
+func decodeStatusResponse(data []byte) *StatusResponse {
+	delivered := int(data[2]&0x0f)<<9 | int(data[3])<<1 | int(data[4]>>7)
+	notDelivered := int(data[4]&0x03)<<8 | int(data[5])
+	minutes := int(data[7]&0x7f)<<6 | int(data[8]>>2)
+	reservoir := int(data[8]&0x03)<<8 | int(data[9])
+	sr := &StatusResponse{
+		Delivery:          DeliveryStatus(data[1] >> 4),
+		Progress:          PodProgress(data[1] & 0x0f),
+		Delivered:         float64(delivered) / PulsesPerUnit,
+		MessageCounter:    data[4] >> 3 & 0x0f,
+		BolusNotDelivered: float64(notDelivered) / PulsesPerUnit,
+		Alerts:            data[6]<<1 | data[7]>>7,
+		Active:            time.Duration(minutes) * time.Minute,
+		Reservoir:         float64(reservoir) / PulsesPerUnit,
+	}
+	if reservoir == reservoirOverReading {
+		sr.Reservoir = MaxReservoirReading
+		sr.ReservoirOver = true
+	}
+	return sr
+}
+
+// PodErrorCode is the reason given in an ErrorResponse
+type PodErrorCode byte
+
+const (
+	// PodErrorBadNonce means the nonce was wrong; the response carries a
+	// word to resync the nonce generator with
+	PodErrorBadNonce PodErrorCode = 0x14
+)
+
+// ErrorResponse is the pod refusing a command
+type ErrorResponse struct {
+	Code PodErrorCode
+	Data []byte
+}
+
+func (er *ErrorResponse) Type() BlockType {
+	return BlockErrorResponse
+}
+
+func (er *ErrorResponse) Encode() []byte {
+	return encodeBlock(BlockErrorResponse, append([]byte{byte(er.Code)}, er.Data...))
+}
+
+// SyncWord returns the word a bad nonce error carries
+func (er *ErrorResponse) SyncWord() uint16 {
+	if er.Code != PodErrorBadNonce || len(er.Data) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(er.Data[0:2])
+}
+
+func decodeErrorResponse(data []byte) (*ErrorResponse, error) {
+	if len(data) < 3 {
+		return nil, fmt.Errorf("short error response: %x", data)
+	}
+	return &ErrorResponse{PodErrorCode(data[2]), append([]byte(nil), data[3:]...)}, nil
+}
+
+// PodInfoResponse answers a GetStatusCommand for anything but
+// PodInfoNormal; Data is left for the caller to decode by InfoType
+type PodInfoResponse struct {
+	InfoType PodInfoType
+	Data     []byte
+}
+
+func (pir *PodInfoResponse) Type() BlockType {
+	return BlockPodInfoResponse
+}
+
+func (pir *PodInfoResponse) Encode() []byte {
+	return encodeBlock(BlockPodInfoResponse, append([]byte{byte(pir.InfoType)}, pir.Data...))
+}
+
+func decodePodInfoResponse(data []byte) (*PodInfoResponse, error) {
+	if len(data) < 3 {
+		return nil, fmt.Errorf("short pod info response: %x", data)
+	}
+	return &PodInfoResponse{PodInfoType(data[2]), append([]byte(nil), data[3:]...)}, nil
+}
+
+const (
+	// version responses come in two lengths: answering address assignment,
+	// and answering setup with 7 more bytes of pod configuration ahead
+	versionResponseAssignLength = 0x15
+	versionResponseSetupLength  = 0x1b
+)
+
+// VersionResponse is the pod identifying itself while being paired
+type VersionResponse struct {
+	PMVersion [3]byte
+	PIVersion [3]byte
+	Progress  PodProgress
+	// Lot and TID seed the nonce generator
+	Lot     uint32
+	TID     uint32
+	Address uint32
+	data    []byte
+}
+
+func (vr *VersionResponse) Type() BlockType {
+	return BlockVersionResponse
+}
+
+func (vr *VersionResponse) Encode() []byte {
+	return encodeBlock(BlockVersionResponse, vr.data)
+}
+
+func decodeVersionResponse(data []byte) (*VersionResponse, error) {
+	var offset int
+	switch data[1] {
+	case versionResponseAssignLength:
+		offset = 2
+	case versionResponseSetupLength:
+		offset = 9
+	default:
+		return nil, fmt.Errorf("unknown version response: %x", data)
+	}
+	vr := &VersionResponse{
+		Progress: PodProgress(data[offset+7] & 0x0f),
+		Lot:      binary.BigEndian.Uint32(data[offset+8 : offset+12]),
+		TID:      binary.BigEndian.Uint32(data[offset+12 : offset+16]),
+		Address:  binary.BigEndian.Uint32(data[len(data)-4:]),
+		data:     append([]byte(nil), data[2:]...),
+	}
+	copy(vr.PMVersion[:], data[offset:offset+3])
+	copy(vr.PIVersion[:], data[offset+3:offset+6])
+	return vr, nil
+}
+
+// CancelDeliveryCommand stops some kinds of delivery, optionally beeping
+type CancelDeliveryCommand struct {
+	nonce    uint32
+	Delivery DeliveryType
+	Beep     BeepType
+}
+
+// BeepType is a sound the pod makes when a command takes effect
+type BeepType byte
+
+const (
+	BeepNone          BeepType = 0x00
+	BeepBeepBeepBeep  BeepType = 0x01
+	BeepBipBeep       BeepType = 0x02
+	BeepBipBip        BeepType = 0x03
+	BeepBeep          BeepType = 0x04
+	BeepBeepBeepBeep2 BeepType = 0x05
+	BeepBeeeep        BeepType = 0x06
+)
+
+func (cdc *CancelDeliveryCommand) Type() BlockType {
+	return BlockCancelDelivery
+}
+
+func (cdc *CancelDeliveryCommand) Nonce() uint32 {
+	return cdc.nonce
+}
+
+func (cdc *CancelDeliveryCommand) SetNonce(nonce uint32) {
+	cdc.nonce = nonce
+}
+
+func (cdc *CancelDeliveryCommand) Encode() []byte {
+	param := byte(cdc.Beep)<<4 | byte(cdc.Delivery)&0x0f
+	return encodeBlock(BlockCancelDelivery, encodeNonce(cdc.nonce, []byte{param}))
+}
+
+// DeactivatePodCommand stops the pod for good
+type DeactivatePodCommand struct {
+	nonce uint32
+}
+
+func (dpc *DeactivatePodCommand) Type() BlockType {
+	return BlockDeactivatePod
+}
+
+func (dpc *DeactivatePodCommand) Nonce() uint32 {
+	return dpc.nonce
+}
+
+func (dpc *DeactivatePodCommand) SetNonce(nonce uint32) {
+	dpc.nonce = nonce
+}
+
+func (dpc *DeactivatePodCommand) Encode() []byte {
+	return encodeBlock(BlockDeactivatePod, encodeNonce(dpc.nonce, nil))
+}
+
+func (er *ErrorResponse) Error() string {
+	return fmt.Sprintf("pod refused command: error %#x (%x)", byte(er.Code), er.Data)
+}
+
+// IsPodError returns whether err is the pod refusing with code
+func IsPodError(err error, code PodErrorCode) bool {
+	er, ok := err.(*ErrorResponse)
+	return ok && er.Code == code
+}
@@ -0,0 +1,34 @@
+// crc.go contains the checksums used by Omnipod packets and messages
+
+package omnipod
+
+import (
+	"github.com/thecubic/gorileylink"
+)
+
+const (
+	// crc8Polynomial is the CRC-8 polynomial trailing every packet
+	crc8Polynomial = 0x07
+	// crc16Polynomial is the CRC-16 polynomial trailing every message
+	crc16Polynomial = 0x8005
+)
+
+var (
+	crc8Table  = gorileylink.NewCRC8Table(crc8Polynomial)
+	crc16Table = gorileylink.NewCRC16Table(crc16Polynomial)
+)
+
+// CRC8 computes the packet checksum
+func CRC8(data []byte) byte {
+	return crc8Table.Checksum(data)
+}
+
+// CRC16 computes the message checksum.  The pod shifts the register right
+// while indexing an MSB-first table, and so must we
+func CRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc>>8 ^ crc16Table[byte(crc)^b]
+	}
+	return crc
+}
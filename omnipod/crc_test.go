@@ -0,0 +1,21 @@
+// crc_test.go contains tests of the Omnipod checksums
+
+package omnipod
+
+import (
+	"testing"
+)
+
+func TestCRC8(t *testing.T) {
+	// CRC-8/SMBUS check value
+	if crc := CRC8([]byte("123456789")); crc != 0xf4 {
+		t.Errorf("CRC8 %02x", crc)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// the GetStatus message in OmniKit's message tests
+	if crc := CRC16(mustDecodeHex(t, "1f01482a10030e0100")); crc != 0x802c {
+		t.Errorf("CRC16 %04x", crc)
+	}
+}
@@ -0,0 +1,395 @@
+// insulin.go contains the insulin schedule commands for basal, temp basal
+// and bolus delivery, and the extra blocks that time their pulses
+
+package omnipod
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/thecubic/gorileylink"
+)
+
+const (
+	// schedules are tabled in half hour segments
+	segmentDuration = 30 * time.Minute
+	segmentsPerDay  = 48
+	// a table entry covers at most 16 segments
+	maxEntrySegments = 16
+	// a table entry's pulse count is 10 bits
+	maxEntryPulses = 0x3ff
+	// boluses are delivered a pulse every 2 seconds
+	bolusPulseInterval = 2 * time.Second
+	// a zero rate is timed as a pulse that never comes
+	zeroRatePulseInterval = 5 * time.Hour
+	// rate entries count tenths of pulses in 16 bits
+	maxRateEntryPulses = 0xffff / 10
+	// temp basals run from 30 minutes to 12 hours
+	maxTempBasalDuration = 12 * time.Hour
+	// MaxBolus is the largest bolus a pod will deliver, in U
+	MaxBolus = 30
+	// MaxTempBasalRate is the highest temp basal a pod will run, in U/h
+	MaxTempBasalRate = 30
+	// RateIncrement is the step basal and temp basal rates are set in,
+	// in U/h
+	RateIncrement = 0.05
+)
+
+// ScheduleType is what a SetInsulinScheduleCommand programs
+type ScheduleType byte
+
+const (
+	ScheduleBasal     ScheduleType = 0x00
+	ScheduleTempBasal ScheduleType = 0x01
+	ScheduleBolus     ScheduleType = 0x02
+)
+
+// InsulinTableEntry is a run of segments delivering the same pulses each
+type InsulinTableEntry struct {
+	Segments int
+	Pulses   int
+	// AlternateSegmentPulse adds a pulse to every other segment, for
+	// rates of an odd number of half pulses
+	AlternateSegmentPulse bool
+}
+
+// encode packs the entry as segments-1, the alternate flag and pulses
+func (ite InsulinTableEntry) encode() []byte {
+	word := uint16(ite.Segments-1)<<12 | uint16(ite.Pulses)&maxEntryPulses
+	if ite.AlternateSegmentPulse {
+		word |= 0x0800
+	}
+	return []byte{byte(word >> 8), byte(word)}
+}
+
+// checksum is what the entry adds to its table's checksum: the bytes of
+// the pulse count for every segment it covers
+func (ite InsulinTableEntry) checksum() uint16 {
+	sum := (ite.Pulses&0xff + ite.Pulses>>8) * ite.Segments
+	if ite.AlternateSegmentPulse {
+		sum += ite.Segments / 2
+	}
+	return uint16(sum)
+}
+
+// segmentPulses returns the pulses per half hour at a rate in U/h, and
+// whether a half pulse is left over
+func segmentPulses(rate float64) (int, bool) {
+	halfPulses := int(math.Round(rate * segmentDuration.Hours() * PulsesPerUnit * 2))
+	return halfPulses / 2, halfPulses%2 != 0
+}
+
+// insulinTable compresses per-segment rates (U/h) into table entries
+func insulinTable(rates []float64) []InsulinTableEntry {
+	var entries []InsulinTableEntry
+	for i := 0; i < len(rates); {
+		pulses, alternate := segmentPulses(rates[i])
+		n := 1
+		for i+n < len(rates) && n < maxEntrySegments && rates[i+n] == rates[i] {
+			n++
+		}
+		entries = append(entries, InsulinTableEntry{n, pulses, alternate})
+		i += n
+	}
+	return entries
+}
+
+// SetInsulinScheduleCommand programs a delivery table into the pod.  It
+// is always followed in the same message by the extra block for its type
+type SetInsulinScheduleCommand struct {
+	nonce    uint32
+	Schedule ScheduleType
+	// header is the 5 bytes ahead of the table, which depend on the type
+	header  []byte
+	Entries []InsulinTableEntry
+}
+
+func (sisc *SetInsulinScheduleCommand) Type() BlockType {
+	return BlockSetInsulinSchedule
+}
+
+func (sisc *SetInsulinScheduleCommand) Nonce() uint32 {
+	return sisc.nonce
+}
+
+func (sisc *SetInsulinScheduleCommand) SetNonce(nonce uint32) {
+	sisc.nonce = nonce
+}
+
+// checksum covers the header and every segment of the table
+func (sisc *SetInsulinScheduleCommand) checksum() uint16 {
+	var sum uint16
+	for _, b := range sisc.header {
+		sum += uint16(b)
+	}
+	for _, entry := range sisc.Entries {
+		sum += entry.checksum()
+	}
+	return sum
+}
+
+func (sisc *SetInsulinScheduleCommand) Encode() []byte {
+	checksum := sisc.checksum()
+	data := []byte{byte(sisc.Schedule), byte(checksum >> 8), byte(checksum)}
+	data = append(data, sisc.header...)
+	for _, entry := range sisc.Entries {
+		data = append(data, entry.encode()...)
+	}
+	return encodeBlock(BlockSetInsulinSchedule, encodeNonce(sisc.nonce, data))
+}
+
+// scheduleHeader lays out the byte and two words ahead of a table
+func scheduleHeader(b byte, w1 uint16, w2 uint16) []byte {
+	return []byte{b, byte(w1 >> 8), byte(w1), byte(w2 >> 8), byte(w2)}
+}
+
+// BeepOptions are the beeps that accompany an insulin schedule
+type BeepOptions struct {
+	Acknowledgement bool
+	Completion      bool
+	// ProgramReminder repeats a beep this often while the schedule runs,
+	// to the minute up to 63 minutes; zero for none
+	ProgramReminder time.Duration
+}
+
+func (bo BeepOptions) encode() byte {
+	b := byte(bo.ProgramReminder/time.Minute) & 0x3f
+	if bo.Acknowledgement {
+		b |= 0x80
+	}
+	if bo.Completion {
+		b |= 0x40
+	}
+	return b
+}
+
+// RateEntry is a stretch of delivery as the extra blocks time it
+type RateEntry struct {
+	TotalPulses        float64
+	DelayBetweenPulses time.Duration
+}
+
+// encode packs tenths of pulses and the delay in hundredths of a
+// millisecond
+func (re RateEntry) encode() []byte {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[0:2], uint16(math.Round(re.TotalPulses*10)))
+	binary.BigEndian.PutUint32(data[2:6], uint32(re.DelayBetweenPulses/(10*time.Microsecond)))
+	return data
+}
+
+// duration returns how long the entry takes to deliver
+func (re RateEntry) duration() time.Duration {
+	if re.TotalPulses == 0 {
+		return segmentDuration
+	}
+	return time.Duration(re.TotalPulses * float64(re.DelayBetweenPulses))
+}
+
+// rateEntries times delivery at a rate (U/h) over a duration, split so
+// every entry's pulses fit.  A zero rate takes an entry per half hour
+func rateEntries(rate float64, duration time.Duration) []RateEntry {
+	var entries []RateEntry
+	if rate == 0 {
+		for d := time.Duration(0); d < duration; d += segmentDuration {
+			entries = append(entries, RateEntry{0, zeroRatePulseInterval})
+		}
+		return entries
+	}
+	// rates are whole pulses per hour, which keeps the delay exact
+	pulsesPerHour := int(math.Round(rate / PulseSize))
+	delay := time.Hour / time.Duration(pulsesPerHour)
+	pulses := float64(pulsesPerHour) * duration.Hours()
+	for pulses > 0 {
+		n := math.Min(pulses, maxRateEntryPulses)
+		entries = append(entries, RateEntry{n, delay})
+		pulses -= n
+	}
+	return entries
+}
+
+// encodeExtra lays out an extra block: beeps, a byte, the pulses left
+// in tenths, the delay until the next tenth and the rate entries
+func encodeExtra(bt BlockType, beep BeepOptions, b byte, remaining float64, delay time.Duration, entries []RateEntry) []byte {
+	data := make([]byte, 8, 8+6*len(entries))
+	data[0] = beep.encode()
+	data[1] = b
+	binary.BigEndian.PutUint16(data[2:4], uint16(math.Round(remaining*10)))
+	binary.BigEndian.PutUint32(data[4:8], uint32(delay/(10*time.Microsecond)))
+	for _, entry := range entries {
+		data = append(data, entry.encode()...)
+	}
+	return encodeBlock(bt, data)
+}
+
+// BolusExtraCommand times the pulses of a bolus
+type BolusExtraCommand struct {
+	Beep  BeepOptions
+	Units float64
+}
+
+func (bec *BolusExtraCommand) Type() BlockType {
+	return BlockBolusExtra
+}
+
+func (bec *BolusExtraCommand) Encode() []byte {
+	data := make([]byte, 13)
+	data[0] = bec.Beep.encode()
+	binary.BigEndian.PutUint16(data[1:3], uint16(math.Round(bec.Units*PulsesPerUnit*10)))
+	binary.BigEndian.PutUint32(data[3:7], uint32(bolusPulseInterval/(10*time.Microsecond)))
+	// the rest would be an extended bolus
+	return encodeBlock(BlockBolusExtra, data)
+}
+
+// NewBolus returns the blocks that deliver a bolus of units U.  It
+// refuses unless the bolus is a whole number of pulses within both
+// maxBolus, the caller's limit, and the pod's own MaxBolus
+func NewBolus(units float64, maxBolus float64, beep BeepOptions) ([]MessageBlock, error) {
+	if maxBolus <= 0 {
+		return nil, fmt.Errorf("bolus refused: no max bolus limit")
+	}
+	limit := math.Min(maxBolus, MaxBolus)
+	if units < PulseSize || units > limit {
+		return nil, fmt.Errorf("bolus refused: %v U is outside %v-%v U", units, PulseSize, limit)
+	}
+	exact := units * PulsesPerUnit
+	if math.Abs(exact-math.Round(exact)) > 1e-6 {
+		return nil, fmt.Errorf("bolus refused: %v U is not a multiple of %v U", units, PulseSize)
+	}
+	pulses := int(math.Round(exact))
+	fieldA := uint16(pulses) * uint16(bolusPulseInterval/time.Second) * 8
+	schedule := &SetInsulinScheduleCommand{
+		Schedule: ScheduleBolus,
+		header:   scheduleHeader(1, fieldA, uint16(pulses)),
+		Entries:  []InsulinTableEntry{{1, pulses, false}},
+	}
+	return []MessageBlock{schedule, &BolusExtraCommand{beep, units}}, nil
+}
+
+// TempBasalExtraCommand times the pulses of a temp basal
+type TempBasalExtraCommand struct {
+	Beep    BeepOptions
+	Entries []RateEntry
+}
+
+func (tbec *TempBasalExtraCommand) Type() BlockType {
+	return BlockTempBasalExtra
+}
+
+func (tbec *TempBasalExtraCommand) Encode() []byte {
+	var remaining float64
+	delay := zeroRatePulseInterval
+	if len(tbec.Entries) > 0 {
+		remaining = tbec.Entries[0].TotalPulses
+		delay = tbec.Entries[0].DelayBetweenPulses
+	}
+	return encodeExtra(BlockTempBasalExtra, tbec.Beep, 0x00, remaining, delay, tbec.Entries)
+}
+
+// NewTempBasal returns the blocks that run a temp basal of rate U/h for
+// a whole number of half hours.  It refuses unless the rate is a multiple
+// of RateIncrement within both maxBasal, the caller's limit, and the pod's
+// own MaxTempBasalRate, so that both blocks describe the same delivery
+func NewTempBasal(rate float64, duration time.Duration, maxBasal float64, beep BeepOptions) ([]MessageBlock, error) {
+	if maxBasal <= 0 {
+		return nil, fmt.Errorf("temp basal refused: no max basal limit")
+	}
+	limit := math.Min(maxBasal, MaxTempBasalRate)
+	steps := rate / RateIncrement
+	if duration < segmentDuration || duration > maxTempBasalDuration || duration%segmentDuration != 0 {
+		return nil, fmt.Errorf("temp basal duration %v is not a half hour multiple up to %v", duration, maxTempBasalDuration)
+	} else if rate < 0 || rate > limit {
+		return nil, fmt.Errorf("temp basal refused: %v U/h is outside 0-%v U/h", rate, limit)
+	} else if math.Abs(steps-math.Round(steps)) > 1e-6 {
+		return nil, fmt.Errorf("temp basal refused: %v U/h is not a multiple of %v U/h", rate, RateIncrement)
+	}
+	// the same rate, free of float error, for both blocks
+	rate = math.Round(steps) * RateIncrement
+	segments := int(duration / segmentDuration)
+	rates := make([]float64, segments)
+	for i := range rates {
+		rates[i] = rate
+	}
+	first, _ := segmentPulses(rate)
+	schedule := &SetInsulinScheduleCommand{
+		Schedule: ScheduleTempBasal,
+		header:   scheduleHeader(byte(segments), uint16(segmentDuration/time.Second)<<3, uint16(first)),
+		Entries:  insulinTable(rates),
+	}
+	extra := &TempBasalExtraCommand{beep, rateEntries(rate, duration)}
+	return []MessageBlock{schedule, extra}, nil
+}
+
+// BasalScheduleExtraCommand times the pulses of a basal schedule, from
+// where in the day it is programmed
+type BasalScheduleExtraCommand struct {
+	Beep BeepOptions
+	// CurrentEntry is the entry running now, with Remaining pulses left
+	// and Delay until the next tenth of a pulse
+	CurrentEntry int
+	Remaining    float64
+	Delay        time.Duration
+	Entries      []RateEntry
+}
+
+func (bsec *BasalScheduleExtraCommand) Type() BlockType {
+	return BlockBasalScheduleExtra
+}
+
+func (bsec *BasalScheduleExtraCommand) Encode() []byte {
+	return encodeExtra(BlockBasalScheduleExtra, bsec.Beep, byte(bsec.CurrentEntry), bsec.Remaining, bsec.Delay, bsec.Entries)
+}
+
+// NewBasalSchedule returns the blocks that program a day's basal
+// schedule, with the pod's clock at sinceMidnight
+func NewBasalSchedule(schedule gorileylink.BasalSchedule, sinceMidnight time.Duration, beep BeepOptions) ([]MessageBlock, error) {
+	err := schedule.Validate(float64(maxEntryPulses)/PulsesPerUnit, PulseSize)
+	if err != nil {
+		return nil, err
+	} else if sinceMidnight < 0 || sinceMidnight >= 24*time.Hour {
+		return nil, fmt.Errorf("time of day %v is out of range", sinceMidnight)
+	}
+	rates := make([]float64, segmentsPerDay)
+	for i := range rates {
+		rates[i] = schedule.Rate(time.Duration(i) * segmentDuration)
+	}
+
+	segment := int(sinceMidnight / segmentDuration)
+	left := segmentDuration - sinceMidnight%segmentDuration
+	pulses, _ := segmentPulses(rates[segment])
+	pulsesLeft := int(float64(pulses) * float64(left) / float64(segmentDuration))
+	table := &SetInsulinScheduleCommand{
+		Schedule: ScheduleBasal,
+		header:   scheduleHeader(byte(segment), uint16(left/time.Second)<<3, uint16(pulsesLeft)),
+		Entries:  insulinTable(rates),
+	}
+
+	extra := &BasalScheduleExtraCommand{Beep: beep}
+	var start time.Duration
+	for i, entry := range schedule {
+		end := 24 * time.Hour
+		if i+1 < len(schedule) {
+			end = schedule[i+1].Start
+		}
+		for _, re := range rateEntries(entry.Rate, end-entry.Start) {
+			if start <= sinceMidnight && sinceMidnight < start+re.duration() {
+				// the pulses left in the current entry, rounded down to a
+				// tenth, and how long until that tenth is due
+				extra.CurrentEntry = len(extra.Entries)
+				elapsed := sinceMidnight - start
+				if re.TotalPulses == 0 {
+					extra.Delay = re.DelayBetweenPulses - elapsed
+				} else {
+					exact := re.TotalPulses - float64(elapsed)/float64(re.DelayBetweenPulses)
+					extra.Remaining = math.Floor(exact*10) / 10
+					extra.Delay = time.Duration((exact - extra.Remaining) * float64(re.DelayBetweenPulses))
+				}
+			}
+			extra.Entries = append(extra.Entries, re)
+			start += re.duration()
+		}
+	}
+	return []MessageBlock{table, extra}, nil
+}
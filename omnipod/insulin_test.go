@@ -0,0 +1,133 @@
+// insulin_test.go contains tests of the insulin schedule commands and
+// their checksums, against known encodings including OmniKit's
+
+package omnipod
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// encodeBlocks stamps the schedule with a nonce and lays the blocks out
+func encodeBlocks(blocks []MessageBlock, nonce uint32) []string {
+	blocks[0].(NonceBlock).SetNonce(nonce)
+	var encoded []string
+	for _, block := range blocks {
+		encoded = append(encoded, hex.EncodeToString(block.Encode()))
+	}
+	return encoded
+}
+
+func TestNewBolus(t *testing.T) {
+	for _, tc := range []struct {
+		units    float64
+		beep     BeepOptions
+		nonce    uint32
+		schedule string
+		extra    string
+	}{
+		{0.1, BeepOptions{}, 0x243085c8, "1a0e243085c802002501002000020002", ""},
+		{1.5, BeepOptions{Acknowledgement: true}, 0x851072aa, "1a0e851072aa02011e0101e0001e001e", "170d80012c00030d40000000000000"},
+	} {
+		blocks, err := NewBolus(tc.units, 10, tc.beep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded := encodeBlocks(blocks, tc.nonce)
+		if encoded[0] != tc.schedule {
+			t.Errorf("%v U: schedule %s, expected %s", tc.units, encoded[0], tc.schedule)
+		}
+		if tc.extra != "" && encoded[1] != tc.extra {
+			t.Errorf("%v U: extra %s, expected %s", tc.units, encoded[1], tc.extra)
+		}
+	}
+}
+
+func TestNewBolusLimits(t *testing.T) {
+	for _, tc := range []struct {
+		units    float64
+		maxBolus float64
+	}{
+		{1, 0},
+		{5.05, 5},
+		{MaxBolus + 1, 100},
+		{0.07, 5},
+		{0, 5},
+	} {
+		if _, err := NewBolus(tc.units, tc.maxBolus, BeepOptions{}); err == nil {
+			t.Errorf("bolus of %v U with a %v U limit allowed", tc.units, tc.maxBolus)
+		}
+	}
+	if _, err := NewBolus(MaxBolus, 100, BeepOptions{}); err != nil {
+		t.Errorf("bolus of the pod's max refused: %v", err)
+	}
+}
+
+func TestNewTempBasal(t *testing.T) {
+	for _, tc := range []struct {
+		rate     float64
+		duration time.Duration
+		nonce    uint32
+		schedule string
+		extra    string
+	}{
+		{0.2, 30 * time.Minute, 0xea2d0a3b, "1a0eea2d0a3b01007d01384000020002", ""},
+		{1.25, 90 * time.Minute, 0x3a1f0e65, "1a0e3a1f0e650100ac033840000c280c", "160e0000017700dbba00017700dbba00"},
+	} {
+		blocks, err := NewTempBasal(tc.rate, tc.duration, 5, BeepOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded := encodeBlocks(blocks, tc.nonce)
+		if encoded[0] != tc.schedule {
+			t.Errorf("%v U/h: schedule %s, expected %s", tc.rate, encoded[0], tc.schedule)
+		}
+		if tc.extra != "" && encoded[1] != tc.extra {
+			t.Errorf("%v U/h: extra %s, expected %s", tc.rate, encoded[1], tc.extra)
+		}
+	}
+	if _, err := NewTempBasal(1, 45*time.Minute, 5, BeepOptions{}); err == nil {
+		t.Error("temp basal of 45 minutes allowed")
+	}
+}
+
+func TestNewTempBasalLimits(t *testing.T) {
+	for _, tc := range []struct {
+		rate     float64
+		maxBasal float64
+	}{
+		{1, 0},
+		{0.03, 5},
+		{1.26, 5},
+		{2, 1.5},
+		{MaxTempBasalRate + 1, 100},
+		{-0.05, 5},
+	} {
+		if _, err := NewTempBasal(tc.rate, time.Hour, tc.maxBasal, BeepOptions{}); err == nil {
+			t.Errorf("temp basal of %v U/h with a %v U/h limit allowed", tc.rate, tc.maxBasal)
+		}
+	}
+	for _, rate := range []float64{0, MaxTempBasalRate} {
+		if _, err := NewTempBasal(rate, time.Hour, 100, BeepOptions{}); err != nil {
+			t.Errorf("temp basal of %v U/h refused: %v", rate, err)
+		}
+	}
+}
+
+func TestNewTempBasalBlocksAgree(t *testing.T) {
+	// 0.15 isn't exact in floating point; the table and the pulse timing
+	// must still both come out at 3 pulses an hour
+	blocks, err := NewTempBasal(0.15, time.Hour, 5, BeepOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := blocks[0].(*SetInsulinScheduleCommand)
+	if len(schedule.Entries) != 1 || schedule.Entries[0] != (InsulinTableEntry{2, 1, true}) {
+		t.Errorf("table %+v", schedule.Entries)
+	}
+	extra := blocks[1].(*TempBasalExtraCommand)
+	if len(extra.Entries) != 1 || extra.Entries[0] != (RateEntry{3, 20 * time.Minute}) {
+		t.Errorf("rate entries %+v", extra.Entries)
+	}
+}
@@ -0,0 +1,125 @@
+// message.go contains Omnipod messages, and splitting them into packets
+// and back
+
+package omnipod
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// a message is an address, a sequence/length header, the body of
+	// blocks and a CRC16
+	messageHeaderLength = 6
+	messageCRCLength    = 2
+	// message sequence numbers are 4 bits
+	messageSequenceModulus = 16
+	// the body length is 10 bits
+	maxMessageBody = 0x3ff
+)
+
+// Message is a set of command or response blocks sent as one
+type Message struct {
+	Address  uint32
+	Sequence byte
+	// ExpectFollowOn is set when another message will follow straight on
+	ExpectFollowOn bool
+	Blocks         []MessageBlock
+}
+
+// Encode lays the message out: address, follow-on flag, sequence and body
+// length, the blocks, then the CRC16 of all that
+func (m *Message) Encode() ([]byte, error) {
+	var body []byte
+	for _, block := range m.Blocks {
+		body = append(body, block.Encode()...)
+	}
+	if len(body) > maxMessageBody {
+		return nil, fmt.Errorf("message body of %d bytes is too long", len(body))
+	}
+	message := make([]byte, messageHeaderLength, messageHeaderLength+len(body)+messageCRCLength)
+	binary.BigEndian.PutUint32(message[0:4], m.Address)
+	message[4] = (m.Sequence&0x0f)<<2 | byte(len(body)>>8)&0x03
+	if m.ExpectFollowOn {
+		message[4] |= 0x80
+	}
+	message[5] = byte(len(body))
+	message = append(message, body...)
+	crc := CRC16(message)
+	return append(message, byte(crc>>8), byte(crc)), nil
+}
+
+// messageLength returns the whole length of a message from its header
+func messageLength(header []byte) int {
+	return messageHeaderLength + (int(header[4]&0x03)<<8 | int(header[5])) + messageCRCLength
+}
+
+// ParseMessage unpacks a whole message, checking its CRC16 and decoding
+// its blocks
+func ParseMessage(data []byte) (*Message, error) {
+	if len(data) < messageHeaderLength+messageCRCLength {
+		return nil, fmt.Errorf("short message: %x", data)
+	}
+	length := messageLength(data)
+	if len(data) < length {
+		return nil, fmt.Errorf("message is %d bytes, expected %d: %x", len(data), length, data)
+	}
+	data = data[:length]
+	want := binary.BigEndian.Uint16(data[length-messageCRCLength:])
+	if got := CRC16(data[:length-messageCRCLength]); got != want {
+		return nil, fmt.Errorf("bad CRC16: %04x, expected %04x", got, want)
+	}
+	blocks, err := DecodeBlocks(data[messageHeaderLength : length-messageCRCLength])
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Address:        binary.BigEndian.Uint32(data[0:4]),
+		Sequence:       data[4] >> 2 & 0x0f,
+		ExpectFollowOn: data[4]&0x80 != 0,
+		Blocks:         blocks,
+	}, nil
+}
+
+// Packets splits an encoded message into a packet of the first type
+// followed by CON packets, all addressed to address.  Sequence numbers
+// are left for the packet session to fill in
+func Packets(address uint32, first PacketType, message []byte) []*Packet {
+	var packets []*Packet
+	pt := first
+	for len(message) > 0 {
+		n := len(message)
+		if n > MaxPacketData {
+			n = MaxPacketData
+		}
+		packets = append(packets, &Packet{Address: address, Type: pt, Data: message[:n]})
+		message = message[n:]
+		pt = PacketTypeCON
+	}
+	return packets
+}
+
+// MessageAssembler collects the packets of a message as they arrive
+type MessageAssembler struct {
+	data []byte
+}
+
+// Add appends a packet's data, returning whether the message is complete
+func (ma *MessageAssembler) Add(packet *Packet) (bool, error) {
+	if len(ma.data) == 0 && packet.Type != PacketTypePOD && packet.Type != PacketTypePDM {
+		return false, fmt.Errorf("message starts with a %v packet", packet.Type)
+	} else if len(ma.data) > 0 && packet.Type != PacketTypeCON {
+		return false, fmt.Errorf("message continues with a %v packet", packet.Type)
+	}
+	ma.data = append(ma.data, packet.Data...)
+	if len(ma.data) < messageHeaderLength {
+		return false, nil
+	}
+	return len(ma.data) >= messageLength(ma.data), nil
+}
+
+// Message decodes the message once it is complete
+func (ma *MessageAssembler) Message() (*Message, error) {
+	return ParseMessage(ma.data)
+}
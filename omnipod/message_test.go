@@ -0,0 +1,81 @@
+// message_test.go contains tests of encoding messages and carrying them
+// in packets
+
+package omnipod
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestMessageEncode(t *testing.T) {
+	message := &Message{Address: 0x1f01482a, Sequence: 4, Blocks: []MessageBlock{&GetStatusCommand{}}}
+	encoded, err := message.Encode()
+	if err != nil {
+		t.Fatal(err)
+	} else if got := hex.EncodeToString(encoded); got != "1f01482a10030e0100802c" {
+		t.Errorf("encoded as %s", got)
+	}
+	parsed, err := ParseMessage(encoded)
+	if err != nil {
+		t.Fatal(err)
+	} else if parsed.Address != 0x1f01482a || parsed.Sequence != 4 || parsed.ExpectFollowOn || len(parsed.Blocks) != 1 {
+		t.Errorf("parsed %+v", parsed)
+	}
+
+	encoded[len(encoded)-1] ^= 0x01
+	if _, err = ParseMessage(encoded); err == nil {
+		t.Error("bad CRC16 parsed")
+	}
+}
+
+func TestMessageAcrossPackets(t *testing.T) {
+	blocks, err := NewBolus(1.5, 5, BeepOptions{Acknowledgement: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks[0].(NonceBlock).SetNonce(0x851072aa)
+	message := &Message{Address: 0x1f01482a, Sequence: 9, Blocks: blocks}
+	encoded, err := message.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packets := Packets(0x1f01482a, PacketTypePDM, encoded)
+	if len(packets) != 2 || packets[0].Type != PacketTypePDM || packets[1].Type != PacketTypeCON {
+		t.Fatalf("split into %d packets", len(packets))
+	} else if len(packets[0].Data) != MaxPacketData {
+		t.Errorf("first packet carries %d bytes", len(packets[0].Data))
+	}
+	var ma MessageAssembler
+	for i, packet := range packets {
+		// as the packets arrive off air
+		received, err := ParsePacket(packet.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		complete, err := ma.Add(received)
+		if err != nil {
+			t.Fatal(err)
+		} else if complete != (i == len(packets)-1) {
+			t.Errorf("complete after packet %d: %v", i, complete)
+		}
+	}
+	parsed, err := ma.Message()
+	if err != nil {
+		t.Fatal(err)
+	} else if parsed.Sequence != 9 || len(parsed.Blocks) != 2 {
+		t.Fatalf("parsed %+v", parsed)
+	}
+	for i, block := range parsed.Blocks {
+		if !bytes.Equal(block.Encode(), blocks[i].Encode()) {
+			t.Errorf("block %d reassembled as %x, sent %x", i, block.Encode(), blocks[i].Encode())
+		}
+	}
+
+	var out MessageAssembler
+	if _, err = out.Add(packets[1]); err == nil {
+		t.Error("message started with a CON packet")
+	}
+}
@@ -0,0 +1,78 @@
+// nonce.go contains the nonce generator pods check commands against
+
+package omnipod
+
+// nonceTableSize is the generator's two state words and 16 nonces
+const nonceTableSize = 18
+
+// Nonce generates the nonces a pod expects, from its lot and TID and a
+// seed that starts at zero and changes on every resync
+type Nonce struct {
+	lot   uint32
+	tid   uint32
+	seed  uint16
+	table [nonceTableSize]uint32
+	index byte
+	// Used is how many nonces have been taken since the last seeding
+	Used int
+}
+
+// NewNonce creates a generator seeded with seed, as after a resync
+func NewNonce(lot uint32, tid uint32, seed uint16) *Nonce {
+	n := &Nonce{lot: lot, tid: tid}
+	n.reseed(seed)
+	return n
+}
+
+// reseed starts the generator over from a seed
+func (n *Nonce) reseed(seed uint16) {
+	n.seed = seed
+	n.Used = 0
+	n.table[0] = n.lot&0xffff + n.lot>>16 + 0x55543dc3 + uint32(seed&0xff)
+	n.table[1] = n.tid&0xffff + n.tid>>16 + 0xaaaae44e + uint32(seed>>8)
+	for i := 2; i < nonceTableSize; i++ {
+		n.table[i] = n.generate()
+	}
+	n.index = byte((n.table[0] + n.table[1]) & 0x0f)
+}
+
+// generate advances the state words and returns a new table entry
+func (n *Nonce) generate() uint32 {
+	n.table[0] = n.table[0]>>16 + (n.table[0]&0xffff)*0x5d7f
+	n.table[1] = n.table[1]>>16 + (n.table[1]&0xffff)*0x8ca0
+	return n.table[1] + (n.table[0]&0xffff)<<16
+}
+
+// Seed returns the seed the generator last started from
+func (n *Nonce) Seed() uint16 {
+	return n.seed
+}
+
+// Current returns the nonce the next command should carry
+func (n *Nonce) Current() uint32 {
+	return n.table[2+n.index]
+}
+
+// Next takes the current nonce, moving the generator on
+func (n *Nonce) Next() uint32 {
+	nonce := n.Current()
+	n.table[2+n.index] = n.generate()
+	n.index = byte(nonce & 0x0f)
+	n.Used++
+	return nonce
+}
+
+// Skip moves the generator on count nonces, as when restoring it
+func (n *Nonce) Skip(count int) {
+	for i := 0; i < count; i++ {
+		n.Next()
+	}
+}
+
+// Resync reseeds the generator after the pod answered a command carrying
+// the nonce sent, in a message of sequence number messageSequence, with a
+// bad nonce error and syncWord
+func (n *Nonce) Resync(syncWord uint16, sent uint32, messageSequence byte) {
+	sum := sent&0xffff + uint32(crc16Table[messageSequence]) + n.lot&0xffff + n.tid&0xffff
+	n.reseed(uint16(sum) ^ syncWord)
+}
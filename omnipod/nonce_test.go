@@ -0,0 +1,52 @@
+// nonce_test.go contains tests of the nonce generator
+
+package omnipod
+
+import (
+	"testing"
+)
+
+const (
+	testLot = 42560
+	testTID = 661771
+)
+
+func TestNonce(t *testing.T) {
+	// the nonces OmniKit generates for this lot and TID
+	n := NewNonce(testLot, testTID, 0)
+	for i, want := range []uint32{0x8c61ee59, 0xc0256620, 0x15022c8a, 0xacf076ca} {
+		if nonce := n.Next(); nonce != want {
+			t.Errorf("nonce %d is %08x, expected %08x", i, nonce, want)
+		}
+	}
+	if n.Used != 4 {
+		t.Errorf("%d nonces used", n.Used)
+	}
+
+	// a generator restored by skipping carries on where this one is
+	restored := NewNonce(testLot, testTID, 0)
+	restored.Skip(4)
+	if restored.Current() != n.Current() {
+		t.Errorf("restored generator at %08x, expected %08x", restored.Current(), n.Current())
+	}
+}
+
+func TestNonceResync(t *testing.T) {
+	// a bad nonce error answering the first nonce in message 9; these
+	// values were taken from this generator, not from a capture, and pin
+	// the resync against a change of formula
+	const (
+		syncWord uint16 = 0x92c4
+		sent     uint32 = 0x8c61ee59
+		sequence byte   = 9
+	)
+	n := NewNonce(testLot, testTID, 0)
+	n.Skip(3)
+	n.Resync(syncWord, sent, sequence)
+	if n.Seed() != 0x3f1e || n.Used != 0 {
+		t.Errorf("resynced to seed %04x with %d used", n.Seed(), n.Used)
+	}
+	if n.Current() != 0x0a06c7ea {
+		t.Errorf("resynced nonce %08x", n.Current())
+	}
+}
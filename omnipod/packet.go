@@ -0,0 +1,123 @@
+// packet.go contains the Omnipod Eros RF packet layout
+
+package omnipod
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/thecubic/gorileylink"
+)
+
+const (
+	// Eros pods talk at 433.91 MHz, Manchester encoded, behind this sync
+	// word
+	Frequency uint32 = 433910000
+	SyncWord  uint16 = 0xa55a
+	Encoding         = gorileylink.EncodingManchester
+	// Channel is the RileyLink packet channel pods are reached on
+	Channel gorileylink.RileyLinkPacketChannel = 0x00
+	// BroadcastAddress reaches an unpaired pod
+	BroadcastAddress uint32 = 0xffffffff
+	// MaxPacketData is the most a packet carries; longer messages
+	// continue in CON packets
+	MaxPacketData = 31
+	// sequence numbers are 5 bits
+	sequenceModulus = 32
+)
+
+// PacketType is the top 3 bits of the byte after the address
+type PacketType byte
+
+const (
+	// PacketTypeACK acknowledges a packet, carrying an address
+	PacketTypeACK PacketType = 0x02
+	// PacketTypeCON continues a message begun in an earlier packet
+	PacketTypeCON PacketType = 0x04
+	// PacketTypePDM starts a message from the PDM (or RileyLink)
+	PacketTypePDM PacketType = 0x05
+	// PacketTypePOD starts a message from the pod
+	PacketTypePOD PacketType = 0x07
+)
+
+func (pt PacketType) String() string {
+	switch pt {
+	case PacketTypeACK:
+		return "PacketTypeACK"
+	case PacketTypeCON:
+		return "PacketTypeCON"
+	case PacketTypePDM:
+		return "PacketTypePDM"
+	case PacketTypePOD:
+		return "PacketTypePOD"
+	default:
+		return "PacketTypeUNKNOWN"
+	}
+}
+
+// Packet is a single Eros RF packet
+type Packet struct {
+	Address  uint32
+	Type     PacketType
+	Sequence byte
+	// Data is part of a message, or the acknowledged address for ACKs
+	Data []byte
+}
+
+// NewAckPacket creates an ACK carrying the given address
+func NewAckPacket(address uint32, ackAddress uint32) *Packet {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, ackAddress)
+	return &Packet{Address: address, Type: PacketTypeACK, Data: data}
+}
+
+// AckAddress returns the address an ACK carries
+func (p *Packet) AckAddress() (uint32, error) {
+	if p.Type != PacketTypeACK || len(p.Data) < 4 {
+		return 0, fmt.Errorf("not an ACK: %v %x", p.Type, p.Data)
+	}
+	return binary.BigEndian.Uint32(p.Data[0:4]), nil
+}
+
+// Encode lays the packet out for the radio: address, type and sequence,
+// data and CRC8
+func (p *Packet) Encode() []byte {
+	packet := make([]byte, 5, 6+len(p.Data))
+	binary.BigEndian.PutUint32(packet[0:4], p.Address)
+	packet[4] = byte(p.Type)<<5 | p.Sequence&0x1f
+	packet = append(packet, p.Data...)
+	return append(packet, CRC8(packet))
+}
+
+// ParsePacket unpacks a packet as received, checking its CRC8.  Trailing
+// bytes after a shorter ACK are ignored
+func ParsePacket(packet []byte) (*Packet, error) {
+	if len(packet) < 6 {
+		return nil, fmt.Errorf("short packet: %x", packet)
+	}
+	p := &Packet{
+		Address:  binary.BigEndian.Uint32(packet[0:4]),
+		Type:     PacketType(packet[4] >> 5),
+		Sequence: packet[4] & 0x1f,
+	}
+	end := len(packet) - 1
+	if p.Type == PacketTypeACK && len(packet) > 10 {
+		end = 9
+	}
+	if CRC8(packet[:end]) != packet[end] {
+		return nil, fmt.Errorf("bad CRC8: %x", packet)
+	}
+	p.Data = make([]byte, end-5)
+	copy(p.Data, packet[5:end])
+	switch p.Type {
+	case PacketTypeACK, PacketTypeCON, PacketTypePDM, PacketTypePOD:
+	default:
+		return nil, fmt.Errorf("unknown packet type: %x", packet)
+	}
+	return p, nil
+}
+
+// nextSequence is the sequence number following s
+func nextSequence(s byte) byte {
+	return (s + 1) % sequenceModulus
+}
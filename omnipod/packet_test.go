@@ -0,0 +1,85 @@
+// packet_test.go contains tests of the Eros packet layout
+
+package omnipod
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// a GetStatus message from the PDM to pod 1f01482a, in one packet
+const getStatusPacket = "1f01482aad1f01482a10030e0100802c88"
+
+func TestPacketEncode(t *testing.T) {
+	packet := &Packet{
+		Address:  0x1f01482a,
+		Type:     PacketTypePDM,
+		Sequence: 13,
+		Data:     mustDecodeHex(t, "1f01482a10030e0100802c"),
+	}
+	if got := hex.EncodeToString(packet.Encode()); got != getStatusPacket {
+		t.Errorf("encoded as %s", got)
+	}
+	ack := NewAckPacket(0x1f01482a, 0x1f01482a)
+	ack.Sequence = 4
+	if got := hex.EncodeToString(ack.Encode()); got != "1f01482a441f01482a0a" {
+		t.Errorf("ACK encoded as %s", got)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	p, err := ParsePacket(mustDecodeHex(t, getStatusPacket))
+	if err != nil {
+		t.Fatal(err)
+	} else if p.Address != 0x1f01482a || p.Type != PacketTypePDM || p.Sequence != 13 {
+		t.Errorf("parsed %08x %v %d", p.Address, p.Type, p.Sequence)
+	} else if !bytes.Equal(p.Data, mustDecodeHex(t, "1f01482a10030e0100802c")) {
+		t.Errorf("data %x", p.Data)
+	}
+
+	bad := mustDecodeHex(t, getStatusPacket)
+	bad[len(bad)-1] ^= 0x01
+	if _, err = ParsePacket(bad); err == nil {
+		t.Error("bad CRC8 parsed")
+	}
+	if _, err = ParsePacket(mustDecodeHex(t, "1f01482aad")); err == nil {
+		t.Error("short packet parsed")
+	}
+}
+
+func TestParseAckTruncated(t *testing.T) {
+	// the radio hands over whatever followed a short ACK as well
+	p, err := ParsePacket(mustDecodeHex(t, "1f01482a441f01482a0a"+"00ff55aa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ackAddress, err := p.AckAddress()
+	if err != nil {
+		t.Fatal(err)
+	} else if ackAddress != 0x1f01482a || p.Sequence != 4 || len(p.Data) != 4 {
+		t.Errorf("ACK of %08x, sequence %d, data %x", ackAddress, p.Sequence, p.Data)
+	}
+	// only ACKs are cut short
+	if _, err = ParsePacket(mustDecodeHex(t, getStatusPacket+"00ff")); err == nil {
+		t.Error("PDM packet with trailing bytes parsed")
+	}
+}
+
+func TestNextSequence(t *testing.T) {
+	if s := nextSequence(30); s != 31 {
+		t.Errorf("after 30 comes %d", s)
+	}
+	if s := nextSequence(31); s != 0 {
+		t.Errorf("after 31 comes %d", s)
+	}
+}
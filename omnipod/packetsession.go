@@ -0,0 +1,116 @@
+// packetsession.go contains the packet-level exchange with a pod
+
+package omnipod
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/thecubic/gorileylink"
+)
+
+const (
+	// how long to listen for the pod's answer to a single packet
+	packetListenTimeout = 165 * time.Millisecond
+	// how many times a packet is sent before giving up on the pod
+	packetAttempts = 20
+	// how long to listen after the final ACK for the pod repeating itself
+	finalAckListenTimeout = 100 * time.Millisecond
+	finalAckAttempts      = 5
+)
+
+// PacketSession exchanges packets with one pod, keeping the sequence
+// numbers both sides expect
+type PacketSession struct {
+	radio   gorileylink.PacketRadio
+	Address uint32
+	// Preamble extends the first transmission, to wake a dozing pod
+	Preamble time.Duration
+	sequence byte
+}
+
+// NewPacketSession creates a session with the pod at address, starting
+// at sequence number sequence
+func NewPacketSession(radio gorileylink.PacketRadio, address uint32, sequence byte) *PacketSession {
+	return &PacketSession{
+		radio:    radio,
+		Address:  address,
+		sequence: sequence % sequenceModulus,
+	}
+}
+
+// Sequence returns the sequence number the next packet will be sent with
+func (ps *PacketSession) Sequence() byte {
+	return ps.sequence
+}
+
+// receive unpacks a radio response, if it is a packet from this pod
+func (ps *PacketSession) receive(response *gorileylink.RLCCResponse) (*Packet, bool) {
+	if response.Result != gorileylink.RLRSuccess || len(response.Payload) < 2 {
+		return nil, false
+	}
+	// payload is RF RSSI, packet number, then the packet
+	reply, err := ParsePacket(response.Payload[2:])
+	if err != nil {
+		log.WithField("err", err).Debug("omnipod: undecodable packet")
+		return nil, false
+	} else if reply.Address != ps.Address && ps.Address != BroadcastAddress {
+		log.WithField("address", fmt.Sprintf("%08x", reply.Address)).Debug("omnipod: packet for another pod")
+		return nil, false
+	}
+	return reply, true
+}
+
+// ExchangePacket sends a packet, retransmitting it until the pod answers
+// with the next sequence number, and returns the answer
+func (ps *PacketSession) ExchangePacket(packet *Packet) (*Packet, error) {
+	packet.Sequence = ps.sequence
+	encoded := packet.Encode()
+	for attempt := 1; attempt <= packetAttempts; attempt++ {
+		response, err := ps.radio.SendAndListen(Channel, encoded, 0, 0, Channel, packetListenTimeout, 0, ps.Preamble)
+		if err != nil {
+			return nil, err
+		}
+		reply, ok := ps.receive(response)
+		if !ok {
+			continue
+		} else if reply.Sequence != nextSequence(packet.Sequence) {
+			// the pod is still repeating an answer to an earlier packet
+			log.WithFields(log.Fields{
+				"expected": nextSequence(packet.Sequence),
+				"received": reply.Sequence,
+			}).Debug("omnipod: out of sequence")
+			continue
+		}
+		log.WithFields(log.Fields{
+			"sent":     packet.Type,
+			"received": reply.Type,
+			"attempt":  attempt,
+		}).Debug("omnipod exchange")
+		ps.sequence = nextSequence(reply.Sequence)
+		return reply, nil
+	}
+	return nil, fmt.Errorf("no answer from pod %08x after %d attempts", ps.Address, packetAttempts)
+}
+
+// FinalAck acknowledges the last packet of a message from the pod.  As
+// nothing answers it, the pod is listened to afterwards, and the ACK sent
+// again for as long as the pod keeps repeating its packet
+func (ps *PacketSession) FinalAck(ackAddress uint32) error {
+	ack := NewAckPacket(ps.Address, ackAddress)
+	ack.Sequence = ps.sequence
+	encoded := ack.Encode()
+	for attempt := 1; attempt <= finalAckAttempts; attempt++ {
+		response, err := ps.radio.SendAndListen(Channel, encoded, 0, 0, Channel, finalAckListenTimeout, 0, 0)
+		if err != nil {
+			return err
+		}
+		if _, ok := ps.receive(response); !ok {
+			ps.sequence = nextSequence(ack.Sequence)
+			return nil
+		}
+	}
+	return fmt.Errorf("pod %08x did not take the final ACK", ps.Address)
+}
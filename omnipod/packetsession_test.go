@@ -0,0 +1,87 @@
+// packetsession_test.go contains tests of exchanging packets with a pod
+
+package omnipod
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/thecubic/gorileylink"
+)
+
+// scriptedRadio answers each packet sent with the next of its replies; a
+// nil reply is a packet lost
+type scriptedRadio struct {
+	replies []*Packet
+	sent    [][]byte
+}
+
+func (sr *scriptedRadio) GetPacket(rlpc gorileylink.RileyLinkPacketChannel, timeout time.Duration) (*gorileylink.RLCCResponse, error) {
+	return &gorileylink.RLCCResponse{Result: gorileylink.RLRRecvTimeout}, nil
+}
+
+func (sr *scriptedRadio) SendPacket(rlpc gorileylink.RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, preamble time.Duration) error {
+	sr.sent = append(sr.sent, packet)
+	return nil
+}
+
+func (sr *scriptedRadio) SendAndListen(sendrlpc gorileylink.RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc gorileylink.RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*gorileylink.RLCCResponse, error) {
+	sr.sent = append(sr.sent, packet)
+	if len(sr.replies) == 0 {
+		return &gorileylink.RLCCResponse{Result: gorileylink.RLRRecvTimeout}, nil
+	}
+	reply := sr.replies[0]
+	sr.replies = sr.replies[1:]
+	if reply == nil {
+		return &gorileylink.RLCCResponse{Result: gorileylink.RLRRecvTimeout}, nil
+	}
+	payload := append([]byte{0x40, 0x01}, reply.Encode()...)
+	return &gorileylink.RLCCResponse{Result: gorileylink.RLRSuccess, Payload: payload}, nil
+}
+
+func TestExchangePacketRetransmit(t *testing.T) {
+	const address = 0x1f01482a
+	radio := &scriptedRadio{replies: []*Packet{
+		nil,
+		// a repeat of the answer to an earlier packet
+		{Address: address, Type: PacketTypePOD, Sequence: 30, Data: []byte{0x01}},
+		// another pod's answer
+		{Address: 0x1f000001, Type: PacketTypePOD, Sequence: 0, Data: []byte{0x02}},
+		{Address: address, Type: PacketTypePOD, Sequence: 0, Data: []byte{0x03}},
+	}}
+	ps := NewPacketSession(radio, address, 31)
+	reply, err := ps.ExchangePacket(&Packet{Address: address, Type: PacketTypePDM, Data: []byte{0x10}})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(reply.Data, []byte{0x03}) {
+		t.Errorf("answered with %x", reply.Data)
+	}
+	if len(radio.sent) != 4 {
+		t.Fatalf("sent %d times", len(radio.sent))
+	}
+	for _, sent := range radio.sent[1:] {
+		if !bytes.Equal(sent, radio.sent[0]) {
+			t.Errorf("retransmitted %x as %x", radio.sent[0], sent)
+		}
+	}
+	if radio.sent[0][4]&0x1f != 31 {
+		t.Errorf("sent with sequence %d", radio.sent[0][4]&0x1f)
+	}
+	// the sequence wraps, and moves past the pod's answer
+	if ps.Sequence() != 1 {
+		t.Errorf("next sequence %d", ps.Sequence())
+	}
+}
+
+func TestExchangePacketNoAnswer(t *testing.T) {
+	radio := &scriptedRadio{}
+	ps := NewPacketSession(radio, 0x1f01482a, 0)
+	if _, err := ps.ExchangePacket(&Packet{Address: 0x1f01482a, Type: PacketTypePDM, Data: []byte{0x10}}); err == nil {
+		t.Error("exchange without an answer succeeded")
+	} else if len(radio.sent) != packetAttempts {
+		t.Errorf("sent %d times", len(radio.sent))
+	} else if ps.Sequence() != 0 {
+		t.Errorf("sequence moved to %d", ps.Sequence())
+	}
+}
@@ -0,0 +1,207 @@
+// podsession.go contains the message-level exchange with a paired pod,
+// and the commands built on it
+
+package omnipod
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/thecubic/gorileylink"
+)
+
+// PodSession exchanges messages with a paired pod, keeping its State up
+// to date for saving between sessions
+type PodSession struct {
+	packets *PacketSession
+	nonce   *Nonce
+	State   *PodState
+}
+
+// NewPodSession creates a session with the pod a state describes
+func NewPodSession(radio gorileylink.PacketRadio, state *PodState) *PodSession {
+	return &PodSession{
+		packets: NewPacketSession(radio, state.Address, state.PacketSequence),
+		nonce:   state.nonce(),
+		State:   state,
+	}
+}
+
+// exchangeMessage sends a message a packet at a time and collects the
+// pod's answer from the packets it sends back
+func (ps *PodSession) exchangeMessage(message *Message) (*Message, error) {
+	encoded, err := message.Encode()
+	if err != nil {
+		return nil, err
+	}
+	packets := Packets(ps.State.Address, PacketTypePDM, encoded)
+	var reply *Packet
+	for i, packet := range packets {
+		reply, err = ps.packets.ExchangePacket(packet)
+		ps.State.PacketSequence = ps.packets.Sequence()
+		if err != nil {
+			return nil, err
+		} else if i < len(packets)-1 && reply.Type != PacketTypeACK {
+			return nil, fmt.Errorf("pod answered packet %d of %d with %v", i+1, len(packets), reply.Type)
+		}
+	}
+	ma := &MessageAssembler{}
+	for {
+		complete, err := ma.Add(reply)
+		if err != nil {
+			return nil, err
+		} else if complete {
+			break
+		}
+		reply, err = ps.packets.ExchangePacket(NewAckPacket(ps.State.Address, ps.State.Address))
+		ps.State.PacketSequence = ps.packets.Sequence()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = ps.packets.FinalAck(ps.State.Address)
+	ps.State.PacketSequence = ps.packets.Sequence()
+	if err != nil {
+		return nil, err
+	}
+	response, err := ma.Message()
+	if err != nil {
+		return nil, err
+	}
+	ps.State.MessageSequence = (response.Sequence + 1) % messageSequenceModulus
+	return response, nil
+}
+
+// stampNonces gives every block that needs one a fresh nonce, returning
+// the first
+func (ps *PodSession) stampNonces(blocks []MessageBlock) (uint32, bool) {
+	var first uint32
+	stamped := false
+	for _, block := range blocks {
+		if nb, ok := block.(NonceBlock); ok {
+			nonce := ps.nonce.Next()
+			nb.SetNonce(nonce)
+			if !stamped {
+				first, stamped = nonce, true
+			}
+		}
+	}
+	ps.State.NonceSeed = ps.nonce.Seed()
+	ps.State.NoncesUsed = ps.nonce.Used
+	return first, stamped
+}
+
+// Send sends command blocks in one message and returns the blocks of the
+// answer.  A bad nonce error resyncs the nonce generator and sends the
+// commands once more; any other error response is returned as an error
+func (ps *PodSession) Send(blocks ...MessageBlock) ([]MessageBlock, error) {
+	for attempt := 0; ; attempt++ {
+		nonce, stamped := ps.stampNonces(blocks)
+		message := &Message{Address: ps.State.Address, Sequence: ps.State.MessageSequence, Blocks: blocks}
+		response, err := ps.exchangeMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		er := findError(response.Blocks)
+		if er == nil {
+			return response.Blocks, nil
+		} else if er.Code != PodErrorBadNonce || !stamped || attempt > 0 {
+			return nil, er
+		}
+		log.WithFields(log.Fields{
+			"nonce":    fmt.Sprintf("%08x", nonce),
+			"syncword": fmt.Sprintf("%04x", er.SyncWord()),
+		}).Debug("omnipod: bad nonce, resyncing")
+		ps.nonce.Resync(er.SyncWord(), nonce, message.Sequence)
+	}
+}
+
+// findError returns the error response among blocks, if any
+func findError(blocks []MessageBlock) *ErrorResponse {
+	for _, block := range blocks {
+		if er, ok := block.(*ErrorResponse); ok {
+			return er
+		}
+	}
+	return nil
+}
+
+// sendForStatus sends commands the pod answers with its status
+func (ps *PodSession) sendForStatus(blocks ...MessageBlock) (*StatusResponse, error) {
+	response, err := ps.Send(blocks...)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range response {
+		if sr, ok := block.(*StatusResponse); ok {
+			return sr, nil
+		}
+	}
+	return nil, fmt.Errorf("no status in pod's answer")
+}
+
+// GetStatus returns the pod's status
+func (ps *PodSession) GetStatus() (*StatusResponse, error) {
+	return ps.sendForStatus(&GetStatusCommand{PodInfoNormal})
+}
+
+// GetPodInfo returns one of the pod's other kinds of information
+func (ps *PodSession) GetPodInfo(infoType PodInfoType) (*PodInfoResponse, error) {
+	if infoType == PodInfoNormal {
+		return nil, fmt.Errorf("normal pod info is the status; use GetStatus")
+	}
+	response, err := ps.Send(&GetStatusCommand{infoType})
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range response {
+		if pir, ok := block.(*PodInfoResponse); ok && pir.InfoType == infoType {
+			return pir, nil
+		}
+	}
+	return nil, fmt.Errorf("no pod info %#x in pod's answer", byte(infoType))
+}
+
+// Bolus delivers units U, returning the status once the pod has taken it.
+// It refuses a bolus over maxBolus, the caller's limit, or the pod's own
+func (ps *PodSession) Bolus(units float64, maxBolus float64, beep BeepOptions) (*StatusResponse, error) {
+	blocks, err := NewBolus(units, maxBolus, beep)
+	if err != nil {
+		return nil, err
+	}
+	return ps.sendForStatus(blocks...)
+}
+
+// SetTempBasal runs a temp basal of rate U/h for duration, refusing one
+// over maxBasal, the caller's limit, or the pod's own.  A temp basal
+// already running has to be cancelled first
+func (ps *PodSession) SetTempBasal(rate float64, duration time.Duration, maxBasal float64, beep BeepOptions) (*StatusResponse, error) {
+	blocks, err := NewTempBasal(rate, duration, maxBasal, beep)
+	if err != nil {
+		return nil, err
+	}
+	return ps.sendForStatus(blocks...)
+}
+
+// SetBasalSchedule programs a day's basal schedule, with the pod's clock
+// taken to be at sinceMidnight.  The running basal has to be cancelled
+// first
+func (ps *PodSession) SetBasalSchedule(schedule gorileylink.BasalSchedule, sinceMidnight time.Duration, beep BeepOptions) (*StatusResponse, error) {
+	blocks, err := NewBasalSchedule(schedule, sinceMidnight, beep)
+	if err != nil {
+		return nil, err
+	}
+	return ps.sendForStatus(blocks...)
+}
+
+// CancelDelivery stops the given kinds of delivery
+func (ps *PodSession) CancelDelivery(delivery DeliveryType, beep BeepType) (*StatusResponse, error) {
+	return ps.sendForStatus(&CancelDeliveryCommand{Delivery: delivery, Beep: beep})
+}
+
+// Deactivate stops the pod for good; it can't be used afterwards
+func (ps *PodSession) Deactivate() (*StatusResponse, error) {
+	return ps.sendForStatus(&DeactivatePodCommand{})
+}
@@ -0,0 +1,56 @@
+// podstate.go contains what has to be kept about a paired pod between
+// sessions
+
+package omnipod
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// PodState is what a session needs to pick up talking to a pod where the
+// last one left off
+type PodState struct {
+	Address uint32
+	// Lot and TID identify the pod, and seed its nonces
+	Lot uint32
+	TID uint32
+	// NonceSeed is where the nonce generator last started from, and
+	// NoncesUsed how far it has moved on since
+	NonceSeed  uint16
+	NoncesUsed int
+	// PacketSequence and MessageSequence are the next numbers to send
+	PacketSequence  byte
+	MessageSequence byte
+}
+
+// nonce rebuilds the nonce generator from the state
+func (ps *PodState) nonce() *Nonce {
+	nonce := NewNonce(ps.Lot, ps.TID, ps.NonceSeed)
+	nonce.Skip(ps.NoncesUsed)
+	return nonce
+}
+
+// Save writes the state to a file as JSON
+func (ps *PodState) Save(path string) error {
+	data, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// LoadPodState reads a state written by Save
+func LoadPodState(path string) (*PodState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ps := &PodState{}
+	err = json.Unmarshal(data, ps)
+	if err != nil {
+		return nil, fmt.Errorf("bad pod state in %s: %v", path, err)
+	}
+	return ps, nil
+}
@@ -0,0 +1,24 @@
+// radio.go contains the packet radio a pump session and the listeners
+// talk through
+
+package gorileylink
+
+import (
+	"time"
+)
+
+// PacketRadio is the packet side of a RileyLink.  ConnectedRileyLink is
+// the real thing; VirtualRileyLink stands in for it in tests
+type PacketRadio interface {
+	GetPacket(rlpc RileyLinkPacketChannel, timeout time.Duration) (*RLCCResponse, error)
+	SendPacket(rlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, preamble time.Duration) error
+	SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error)
+}
+
+// TunableRadio is a PacketRadio whose frequency can be changed, as when
+// scanning for the frequency a device is heard best on
+type TunableRadio interface {
+	PacketRadio
+	GetFrequency() (uint32, error)
+	SetFrequency(freq uint32) error
+}
@@ -0,0 +1,107 @@
+// remote.go contains acting as a paired MMT-503 RF remote
+
+package gorileylink
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MMTRemoteButton is a key on the remote
+type MMTRemoteButton byte
+
+const (
+	MMTRemoteButtonSuspend MMTRemoteButton = 0x81 // S
+	MMTRemoteButtonBolus   MMTRemoteButton = 0x82 // B
+	MMTRemoteButtonAct     MMTRemoteButton = 0x84 // ACT
+)
+
+func (mrb MMTRemoteButton) String() string {
+	switch mrb {
+	case MMTRemoteButtonSuspend:
+		return "MMTRemoteButtonSuspend"
+	case MMTRemoteButtonBolus:
+		return "MMTRemoteButtonBolus"
+	case MMTRemoteButtonAct:
+		return "MMTRemoteButtonAct"
+	default:
+		return "MMTRemoteButtonUNKNOWN"
+	}
+}
+
+const (
+	// a remote keeps transmitting while its key is held; the pump only
+	// needs to hear one of the repeats
+	mmtRemoteRepeat      = 10
+	mmtRemoteRepeatDelay = 20 * time.Millisecond
+	// the pump wants a pause between separate key presses
+	mmtRemotePressGap = 500 * time.Millisecond
+)
+
+// MMTRemote transmits key presses as a remote the pump is paired with
+// (see AddRemoteControl).  Remote packets are addressed to the pump like
+// any other, so only that pump acts on them, and carry the remote's ID for
+// it to check against the remotes it has paired
+type MMTRemote struct {
+	rileylink PacketRadio
+	pumpID    []byte
+	remoteID  []byte
+	sequence  byte
+}
+
+// NewMMTRemote creates a remote for the pump of the given serial, with a
+// six-digit ID sent packed as BCD
+func NewMMTRemote(crl PacketRadio, pumpID string, remoteID string) (*MMTRemote, error) {
+	pid, err := ParsePumpID(pumpID)
+	if err != nil {
+		return nil, err
+	}
+	digits, err := ParseRemoteID(remoteID)
+	if err != nil {
+		return nil, err
+	}
+	return &MMTRemote{
+		rileylink: crl,
+		pumpID:    pid,
+		remoteID: []byte{
+			digits[0]<<4 | digits[1],
+			digits[2]<<4 | digits[3],
+			digits[4]<<4 | digits[5],
+		},
+	}, nil
+}
+
+// packet frames a key press as the remote's ID and a sequence, which lets
+// the pump tell a new press from a repeat of the last one
+func (mr *MMTRemote) packet(button MMTRemoteButton) []byte {
+	body := append(append([]byte{}, mr.remoteID...), mr.sequence)
+	msg := &CarelinkMessage{CarelinkMessageType(button), body}
+	return msg.PacketAs(MMTPacketRemote, mr.pumpID)
+}
+
+// Press transmits a single press of a key
+func (mr *MMTRemote) Press(button MMTRemoteButton) error {
+	mr.sequence++
+	log.WithFields(log.Fields{
+		"button":   button,
+		"sequence": mr.sequence,
+	}).Debug("remote press")
+	packet := append(Encode4b6b(mr.packet(button)), 0x00)
+	return mr.rileylink.SendPacket(RLPCPump, packet, mmtRemoteRepeat, mmtRemoteRepeatDelay, 0)
+}
+
+// PressSequence transmits key presses one after another, as a person
+// would to step through an easy bolus
+func (mr *MMTRemote) PressSequence(buttons ...MMTRemoteButton) error {
+	for i, button := range buttons {
+		if i > 0 {
+			time.Sleep(mmtRemotePressGap)
+		}
+		err := mr.Press(button)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// remote_test.go contains tests of acting as an RF remote
+
+package gorileylink
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestRemotePress(t *testing.T) {
+	radio := &sendingRadio{}
+	mr, err := NewMMTRemote(radio, "123456", "012345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mr.PressSequence(MMTRemoteButtonBolus, MMTRemoteButtonAct)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(radio.sent) != 2 {
+		t.Fatalf("%d packets sent", len(radio.sent))
+	}
+	// addressed to the pump, carrying the remote's ID as BCD and the
+	// sequence of the press
+	for i, want := range []string{"a61234568201234501ce", "a612345684012345020b"} {
+		sent := radio.sent[i]
+		if got := hex.EncodeToString(Decode4b6b(sent[:len(sent)-1])); got != want {
+			t.Errorf("press %d sent %s, want %s", i, got, want)
+		}
+	}
+
+	if _, err = NewMMTRemote(radio, "123456", "01234x"); err == nil {
+		t.Error("remote with a non-digit ID")
+	}
+}
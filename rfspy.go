@@ -115,3 +115,8 @@ func (crl *ConnectedRileyLink) SetFrequency(freq uint32) error {
 
 	return nil
 }
+
+// decodeCCRSSI converts the CC's raw RSSI byte of a received packet to dBm
+func decodeCCRSSI(raw byte) int {
+	return int(int8(raw))/2 - 73
+}
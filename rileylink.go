@@ -322,7 +322,7 @@ func (crl *ConnectedRileyLink) readResponse() (*RLCCResponse, error) {
 
 	log.Debug("readResponse")
 	for !responded {
-		respPayload, err := crl.client.ReadCharacteristic(crl.dataChr)
+		respPayload, err = crl.client.ReadCharacteristic(crl.dataChr)
 		if err != nil {
 			log.WithField("err", err).Error("ReadCharacteristic Error")
 			return nil, err
@@ -404,18 +404,52 @@ func (crl *ConnectedRileyLink) GetPacket(rlpc RileyLinkPacketChannel, timeout ti
 	return response, err
 }
 
-// SendPacket [CC] does a thing that will be documented at some point
-func (crl *ConnectedRileyLink) SendPacket() error {
-	var err error
-	_, err = crl.commandCC(RLCSendPacket)
-	return err
-}
-
-// SendAndListen [CC] does a thing that will be documented at some point
-func (crl *ConnectedRileyLink) SendAndListen() error {
-	var err error
-	_, err = crl.commandCC(RLCSendAndListen)
-	return err
+// SendPacket [CC] transmits a packet, optionally repeated with a delay
+// between repeats and an extended preamble (subg_rfspy >= 2.0)
+func (crl *ConnectedRileyLink) SendPacket(rlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, preamble time.Duration) error {
+	payload := make([]byte, 6+len(packet))
+	payload[0] = byte(rlpc)
+	payload[1] = repeat
+	binary.BigEndian.PutUint16(payload[2:4], uint16(delay/time.Millisecond))
+	binary.BigEndian.PutUint16(payload[4:6], uint16(preamble/time.Millisecond))
+	copy(payload[6:], packet)
+	response, err := crl.payloadCommandCC(RLCSendPacket, payload)
+	if err != nil {
+		return err
+	} else if response.Result != RLRSuccess {
+		return fmt.Errorf("Bad result: %v", response.Result)
+	}
+	return nil
+}
+
+// SendAndListen [CC] transmits a packet and then waits for a packet on
+// the listen channel, retrying the transmission if nothing is heard.
+// The response payload is the RF RSSI, the packet number and the packet
+func (crl *ConnectedRileyLink) SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error) {
+	payload := make([]byte, 12+len(packet))
+	payload[0] = byte(sendrlpc)
+	payload[1] = repeat
+	binary.BigEndian.PutUint16(payload[2:4], uint16(delay/time.Millisecond))
+	payload[4] = byte(listenrlpc)
+	binary.BigEndian.PutUint32(payload[5:9], uint32(timeout/time.Millisecond))
+	payload[9] = retries
+	binary.BigEndian.PutUint16(payload[10:12], uint16(preamble/time.Millisecond))
+	copy(payload[12:], packet)
+	response, err := crl.payloadCommandCC(RLCSendAndListen, payload)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"timeout": timeout,
+			"channel": listenrlpc,
+			"err":     err,
+		}).Error("SendAndListen")
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"timeout": timeout,
+		"channel": listenrlpc,
+		"result":  response.Result,
+	}).Debug("SendAndListen")
+	return response, nil
 }
 
 // UpdateRegister [CC] does a thing that will be documented at some point
@@ -0,0 +1,93 @@
+// sensor.go contains decoding what sensor transmitters (MiniLink, Enlite)
+// send to their pump
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	// sensor packets are type, transmitter ID, version, two unknown
+	// bytes, sequence, the current and seven backfilled ISIG readings,
+	// battery and a CRC16
+	sensorPacketLength = 27
+	sensorBackfill     = 7
+)
+
+// SensorPacket is an ISIG broadcast from a sensor transmitter
+type SensorPacket struct {
+	Type          MMTPacketType
+	TransmitterID string
+	Version       byte
+	Adjustment    byte
+	Sequence      byte
+	// ISIG is the current raw sensor signal
+	ISIG int
+	// Backfill is the previous readings, newest first, so a pump that
+	// missed some packets can catch up
+	Backfill []int
+	// Battery is the transmitter's raw battery level
+	Battery  byte
+	Received time.Time
+	RSSI     int
+}
+
+// ISIGNanoamps converts a raw ISIG reading, which carries a 2-bit binary
+// exponent above a 14-bit mantissa in pA, to nA
+func ISIGNanoamps(raw int) float64 {
+	return float64((raw&0x3fff)<<uint(raw>>14)) / 1000
+}
+
+// DecodeSensorPacket unpacks a decoded 0xaa or 0xab packet
+func DecodeSensorPacket(packet []byte) (*SensorPacket, error) {
+	if len(packet) < sensorPacketLength {
+		return nil, fmt.Errorf("sensor packet is %d bytes, expected %d: %x", len(packet), sensorPacketLength, packet)
+	}
+	packet = packet[:sensorPacketLength]
+	mpt := MMTPacketType(packet[0])
+	if mpt != MMTPacketSensor && mpt != MMTPacketSensor2 {
+		return nil, fmt.Errorf("not a sensor packet: %x", packet)
+	} else if CRC16(packet[:25]) != binary.BigEndian.Uint16(packet[25:27]) {
+		return nil, fmt.Errorf("bad CRC16: %x", packet)
+	}
+	sp := &SensorPacket{
+		Type:          mpt,
+		TransmitterID: hex.EncodeToString(packet[1:4]),
+		Version:       packet[4],
+		Adjustment:    packet[6],
+		Sequence:      packet[7],
+		ISIG:          int(binary.BigEndian.Uint16(packet[8:10])),
+		Backfill:      make([]int, sensorBackfill),
+		Battery:       packet[24],
+	}
+	for i := range sp.Backfill {
+		sp.Backfill[i] = int(binary.BigEndian.Uint16(packet[10+i*2 : 12+i*2]))
+	}
+	return sp, nil
+}
+
+// GetSensorPacket waits for a sensor packet on the pump channel.  It
+// returns nil without error when nothing (or something else) is heard
+func GetSensorPacket(radio PacketRadio, timeout time.Duration) (*SensorPacket, error) {
+	response, err := radio.GetPacket(RLPCPump, timeout)
+	if err != nil {
+		return nil, err
+	} else if response.Result != RLRSuccess || len(response.Payload) < 2 {
+		return nil, nil
+	}
+	packet := Decode4b6b(response.Payload[2:])
+	if len(packet) < 1 || (MMTPacketType(packet[0]) != MMTPacketSensor && MMTPacketType(packet[0]) != MMTPacketSensor2) {
+		return nil, nil
+	}
+	sp, err := DecodeSensorPacket(packet)
+	if err != nil {
+		return nil, err
+	}
+	sp.Received = time.Now()
+	sp.RSSI = decodeCCRSSI(response.Payload[0])
+	return sp, nil
+}
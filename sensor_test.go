@@ -0,0 +1,55 @@
+// sensor_test.go contains tests of decoding sensor transmitter packets
+
+package gorileylink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// hearingRadio is a PacketRadio that hears the given packets in turn, 4b6b
+// encoded, then fails
+type hearingRadio struct {
+	sendingRadio
+	heard [][]byte
+}
+
+func (hr *hearingRadio) GetPacket(rlpc RileyLinkPacketChannel, timeout time.Duration) (*RLCCResponse, error) {
+	if len(hr.heard) == 0 {
+		return nil, fmt.Errorf("radio gone")
+	}
+	packet := hr.heard[0]
+	hr.heard = hr.heard[1:]
+	if packet == nil {
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	payload := append([]byte{0x00, 0x01}, Encode4b6b(packet)...)
+	return &RLCCResponse{Result: RLRSuccess, Payload: append(payload, 0x00)}, nil
+}
+
+func TestGetSensorPacket(t *testing.T) {
+	sensor := make([]byte, sensorPacketLength)
+	copy(sensor, []byte{byte(MMTPacketSensor), 0x12, 0x34, 0x56, 0x0d, 0x00, 0x02, 0x2a, 0x41, 0x00})
+	binary.BigEndian.PutUint16(sensor[25:], CRC16(sensor[:25]))
+	radio := &hearingRadio{heard: [][]byte{
+		nil,
+		NewCarelinkShortMessage(CMTGetPumpModel).Packet([]byte{0x12, 0x34, 0x56}),
+		sensor,
+	}}
+	for i := 0; i < 2; i++ {
+		if sp, err := GetSensorPacket(radio, time.Millisecond); sp != nil || err != nil {
+			t.Errorf("heard %+v (%v)", sp, err)
+		}
+	}
+	sp, err := GetSensorPacket(radio, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	} else if sp.TransmitterID != "123456" || sp.Sequence != 0x2a || sp.ISIG != 0x4100 {
+		t.Errorf("heard %+v", sp)
+	}
+	if _, err = GetSensorPacket(radio, time.Millisecond); err == nil {
+		t.Error("failed radio heard")
+	}
+}
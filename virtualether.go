@@ -0,0 +1,289 @@
+// virtualether.go contains a simulated RF medium that virtual radios and
+// devices share, so that tuning and scanning can be exercised offline
+
+package gorileylink
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// Medtronic devices use this sync word
+	MMTSyncWord uint16 = 0xff00
+	// how far off frequency a radio still hears a transmission, by default
+	virtualEtherBandwidth = 100000
+	// how much weaker a transmission is heard at the edge of the bandwidth
+	virtualEtherEdgeLoss = 30
+)
+
+// VirtualRadioConfig is what a radio has to agree on with another to hear
+// it: the same frequency (within the ether's bandwidth) and sync word.
+// Encoding is the line code the radio applies itself; transmissions are
+// heard either way, but a radio decoding a line code the sender didn't
+// apply only hears garbage
+type VirtualRadioConfig struct {
+	Frequency uint32
+	SyncWord  uint16
+	Encoding  SwEncoding
+}
+
+// VirtualDevice is something that answers packets, as VirtualPump does
+type VirtualDevice interface {
+	Receive(packet []byte) []byte
+}
+
+// lineEncode applies a line code to a packet as it goes on air
+func lineEncode(encoding SwEncoding, data []byte) []byte {
+	switch encoding {
+	case Encoding4b6b:
+		return Encode4b6b(data)
+	case EncodingManchester:
+		return EncodeManchester(data)
+	default:
+		return data
+	}
+}
+
+// lineDecode removes a line code from a packet as it comes off air
+func lineDecode(encoding SwEncoding, data []byte) []byte {
+	switch encoding {
+	case Encoding4b6b:
+		return Decode4b6b(data)
+	case EncodingManchester:
+		return DecodeManchester(data)
+	default:
+		return data
+	}
+}
+
+// virtualFrame is a packet as heard by a radio
+type virtualFrame struct {
+	channel RileyLinkPacketChannel
+	data    []byte
+	rssi    int
+}
+
+// virtualStation is anything attached to the ether
+type virtualStation struct {
+	config VirtualRadioConfig
+	// Power is the RSSI the station is heard at when perfectly in tune
+	power   int
+	device  VirtualDevice
+	channel RileyLinkPacketChannel
+	radio   *VirtualEtherRadio
+}
+
+// VirtualEther carries packets between the radios and devices attached
+// to it.  Loss is decided by a random source seeded at creation, so a run
+// repeats exactly as long as packets are sent in the same order
+type VirtualEther struct {
+	sync.Mutex
+	// Loss is the chance (0-1) of any one station missing a transmission
+	Loss float64
+	// Latency is how long every transmission takes to arrive
+	Latency time.Duration
+	// Bandwidth is how far off frequency a station still hears, in Hz
+	Bandwidth uint32
+	random    *rand.Rand
+	stations  []*virtualStation
+}
+
+// NewVirtualEther creates a lossless, instant medium
+func NewVirtualEther(seed int64) *VirtualEther {
+	return &VirtualEther{
+		Bandwidth: virtualEtherBandwidth,
+		random:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// AttachDevice puts a device on the ether, listening and answering on a
+// channel, heard at power dBm by radios in tune with it
+func (ve *VirtualEther) AttachDevice(device VirtualDevice, channel RileyLinkPacketChannel, config VirtualRadioConfig, power int) {
+	ve.Lock()
+	defer ve.Unlock()
+	ve.stations = append(ve.stations, &virtualStation{
+		config:  config,
+		power:   power,
+		device:  device,
+		channel: channel,
+	})
+}
+
+// NewRadio attaches a new radio to the ether, heard at power dBm
+func (ve *VirtualEther) NewRadio(config VirtualRadioConfig, power int) *VirtualEtherRadio {
+	ve.Lock()
+	defer ve.Unlock()
+	radio := &VirtualEtherRadio{ether: ve, heard: make(chan struct{}, 1)}
+	radio.station = &virtualStation{config: config, power: power, radio: radio}
+	ve.stations = append(ve.stations, radio.station)
+	return radio
+}
+
+// hears decides whether a station hears a transmission, and how strongly
+func (ve *VirtualEther) hears(from *virtualStation, to *virtualStation, channel RileyLinkPacketChannel) (int, bool) {
+	if to.config.SyncWord != from.config.SyncWord {
+		return 0, false
+	} else if to.device != nil && to.channel != channel {
+		return 0, false
+	}
+	offset := int64(to.config.Frequency) - int64(from.config.Frequency)
+	if offset < 0 {
+		offset = -offset
+	}
+	if offset > int64(ve.Bandwidth) {
+		return 0, false
+	} else if ve.random.Float64() < ve.Loss {
+		return 0, false
+	}
+	return from.power - int(offset*virtualEtherEdgeLoss/int64(ve.Bandwidth)), true
+}
+
+// transmit sends a packet (line coded by the sender) to every other
+// station that hears it.  Devices answer straight away on their channel
+func (ve *VirtualEther) transmit(from *virtualStation, channel RileyLinkPacketChannel, data []byte) {
+	time.Sleep(ve.Latency)
+	type delivery struct {
+		to   *virtualStation
+		rssi int
+	}
+	var deliveries []delivery
+	ve.Lock()
+	for _, to := range ve.stations {
+		if to == from {
+			continue
+		}
+		if rssi, ok := ve.hears(from, to, channel); ok {
+			deliveries = append(deliveries, delivery{to, rssi})
+		}
+	}
+	ve.Unlock()
+	for _, d := range deliveries {
+		packet := lineDecode(d.to.config.Encoding, data)
+		if d.to.radio != nil {
+			if len(packet) > 0 {
+				d.to.radio.hear(virtualFrame{channel, packet, d.rssi})
+			}
+			continue
+		}
+		reply := d.to.device.Receive(packet)
+		if reply != nil {
+			ve.transmit(d.to, d.to.channel, lineEncode(d.to.config.Encoding, reply))
+		}
+	}
+}
+
+// VirtualEtherRadio is a RileyLink on a VirtualEther; it is a TunableRadio
+type VirtualEtherRadio struct {
+	ether   *VirtualEther
+	station *virtualStation
+	inbox   []virtualFrame
+	heard   chan struct{}
+	packets byte
+}
+
+// GetFrequency returns the frequency the radio is tuned to, in Hz
+func (ver *VirtualEtherRadio) GetFrequency() (uint32, error) {
+	ver.ether.Lock()
+	defer ver.ether.Unlock()
+	return ver.station.config.Frequency, nil
+}
+
+// SetFrequency tunes the radio, in Hz
+func (ver *VirtualEtherRadio) SetFrequency(freq uint32) error {
+	ver.ether.Lock()
+	defer ver.ether.Unlock()
+	ver.station.config.Frequency = freq
+	return nil
+}
+
+// SetSyncWord changes the sync word the radio sends and listens for
+func (ver *VirtualEtherRadio) SetSyncWord(syncWord uint16) {
+	ver.ether.Lock()
+	defer ver.ether.Unlock()
+	ver.station.config.SyncWord = syncWord
+}
+
+// SetEncoding changes the line code the radio applies itself
+func (ver *VirtualEtherRadio) SetEncoding(encoding SwEncoding) {
+	ver.ether.Lock()
+	defer ver.ether.Unlock()
+	ver.station.config.Encoding = encoding
+}
+
+// hear queues a frame for the next listen on its channel
+func (ver *VirtualEtherRadio) hear(frame virtualFrame) {
+	ver.ether.Lock()
+	ver.inbox = append(ver.inbox, frame)
+	ver.ether.Unlock()
+	select {
+	case ver.heard <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the oldest frame heard on a channel, if any
+func (ver *VirtualEtherRadio) take(rlpc RileyLinkPacketChannel) (virtualFrame, bool) {
+	ver.ether.Lock()
+	defer ver.ether.Unlock()
+	for i, frame := range ver.inbox {
+		if frame.channel == rlpc {
+			ver.inbox = append(ver.inbox[:i], ver.inbox[i+1:]...)
+			return frame, true
+		}
+	}
+	return virtualFrame{}, false
+}
+
+// GetPacket waits for a packet on a channel, answering like subg_rfspy
+func (ver *VirtualEtherRadio) GetPacket(rlpc RileyLinkPacketChannel, timeout time.Duration) (*RLCCResponse, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		frame, ok := ver.take(rlpc)
+		if ok {
+			ver.packets++
+			payload := []byte{encodeCCRSSI(frame.rssi), ver.packets}
+			return &RLCCResponse{Result: RLRSuccess, Payload: append(payload, frame.data...)}, nil
+		}
+		select {
+		case <-ver.heard:
+		case <-deadline.C:
+			return &RLCCResponse{Result: RLRRecvTimeout}, nil
+		}
+	}
+}
+
+// SendPacket transmits a packet, and then repeat more times
+func (ver *VirtualEtherRadio) SendPacket(rlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, preamble time.Duration) error {
+	ver.ether.Lock()
+	data := lineEncode(ver.station.config.Encoding, packet)
+	ver.ether.Unlock()
+	for i := 0; i <= int(repeat); i++ {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		ver.ether.transmit(ver.station, rlpc, data)
+	}
+	return nil
+}
+
+// SendAndListen transmits a packet and waits for an answer, transmitting
+// again up to retries times if none comes
+func (ver *VirtualEtherRadio) SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error) {
+	ver.ether.Lock()
+	ver.inbox = nil
+	ver.ether.Unlock()
+	for attempt := 0; attempt <= int(retries); attempt++ {
+		err := ver.SendPacket(sendrlpc, packet, repeat, delay, preamble)
+		if err != nil {
+			return nil, err
+		}
+		response, err := ver.GetPacket(listenrlpc, timeout)
+		if err != nil || response.Result == RLRSuccess {
+			return response, err
+		}
+	}
+	return &RLCCResponse{Result: RLRRecvTimeout}, nil
+}
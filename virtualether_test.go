@@ -0,0 +1,113 @@
+// virtualether_test.go contains tests of radios and a virtual pump on the
+// virtual ether: who hears whom, and scanning for the pump
+
+package gorileylink
+
+import (
+	"testing"
+	"time"
+)
+
+const virtualEtherPumpFrequency = 916600000
+
+// newEtherPump attaches an awake virtual pump to a fresh ether, on
+// 916.6 MHz with the Medtronic sync word; the pump applies 4b6b itself,
+// like a real one, so radios talking to it must not
+func newEtherPump(t *testing.T, seed int64) (*VirtualEther, *VirtualPump) {
+	t.Helper()
+	vp, err := NewVirtualPump(554, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp.awakeUntil = time.Now().Add(time.Hour)
+	ether := NewVirtualEther(seed)
+	ether.AttachDevice(vp, RLPCPump, VirtualRadioConfig{virtualEtherPumpFrequency, MMTSyncWord, Encoding4b6b}, -50)
+	return ether, vp
+}
+
+// askModel sends the pump a model request over the radio and returns
+// whether it answered
+func askModel(t *testing.T, radio PacketRadio, vp *VirtualPump) bool {
+	t.Helper()
+	packet := append(Encode4b6b(NewCarelinkShortMessage(CMTGetPumpModel).Packet(vp.PumpID)), 0x00)
+	response, err := radio.SendAndListen(RLPCPump, packet, 0, 0, RLPCPump, 50*time.Millisecond, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.Result == RLRSuccess
+}
+
+func TestVirtualEtherHearing(t *testing.T) {
+	ether, vp := newEtherPump(t, 1)
+	for _, tc := range []struct {
+		name   string
+		config VirtualRadioConfig
+		heard  bool
+	}{
+		{"in tune", VirtualRadioConfig{virtualEtherPumpFrequency, MMTSyncWord, EncodingNone}, true},
+		{"slightly off", VirtualRadioConfig{virtualEtherPumpFrequency + 50000, MMTSyncWord, EncodingNone}, true},
+		{"mis-tuned", VirtualRadioConfig{868400000, MMTSyncWord, EncodingNone}, false},
+		{"wrong sync word", VirtualRadioConfig{virtualEtherPumpFrequency, 0x5a5a, EncodingNone}, false},
+		{"encoding twice", VirtualRadioConfig{virtualEtherPumpFrequency, MMTSyncWord, Encoding4b6b}, false},
+	} {
+		if heard := askModel(t, ether.NewRadio(tc.config, -50), vp); heard != tc.heard {
+			t.Errorf("%s: heard %v", tc.name, heard)
+		}
+	}
+}
+
+func TestVirtualEtherRSSI(t *testing.T) {
+	ether, vp := newEtherPump(t, 1)
+	packet := append(Encode4b6b(NewCarelinkShortMessage(CMTGetPumpModel).Packet(vp.PumpID)), 0x00)
+	var rssi []int
+	for _, offset := range []uint32{0, 50000} {
+		radio := ether.NewRadio(VirtualRadioConfig{virtualEtherPumpFrequency + offset, MMTSyncWord, EncodingNone}, -50)
+		response, err := radio.SendAndListen(RLPCPump, packet, 0, 0, RLPCPump, 50*time.Millisecond, 0, 0)
+		if err != nil || response.Result != RLRSuccess {
+			t.Fatalf("%d Hz off: %v %v", offset, response, err)
+		}
+		rssi = append(rssi, decodeCCRSSI(response.Payload[0]))
+	}
+	if rssi[0] != -50 || rssi[1] >= rssi[0] {
+		t.Errorf("heard at %d dBm in tune, %d dBm off", rssi[0], rssi[1])
+	}
+}
+
+func TestVirtualEtherTune(t *testing.T) {
+	// a lossy ether, the same every run
+	ether, vp := newEtherPump(t, 42)
+	ether.Loss = 0.2
+	radio := ether.NewRadio(VirtualRadioConfig{868400000, MMTSyncWord, EncodingNone}, -50)
+	mps, err := NewMMTPumpSession(radio, nil, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mps.Tune(MMTFrequenciesWorldwide)
+	if err == nil {
+		t.Error("pump found on the wrong band")
+	} else if freq, _ := radio.GetFrequency(); freq != 868400000 {
+		t.Errorf("radio left on %d Hz", freq)
+	}
+
+	results, err := mps.Tune(MMTFrequenciesUS)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(results) != len(MMTFrequenciesUS) {
+		t.Errorf("%d results", len(results))
+	}
+	if freq, _ := radio.GetFrequency(); freq != virtualEtherPumpFrequency {
+		t.Errorf("tuned to %d Hz", freq)
+	}
+	for _, result := range results {
+		if result.Frequency < virtualEtherPumpFrequency-ether.Bandwidth ||
+			result.Frequency > virtualEtherPumpFrequency+ether.Bandwidth {
+			if result.Replies != 0 {
+				t.Errorf("pump heard on %d Hz", result.Frequency)
+			}
+		}
+	}
+	if !askModel(t, radio, vp) && !askModel(t, radio, vp) {
+		t.Error("pump not heard after tuning")
+	}
+}
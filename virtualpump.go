@@ -0,0 +1,421 @@
+// virtualpump.go contains a simulated Medtronic pump, and a simulated
+// RileyLink to reach it with, for exercising pump sessions offline
+
+package gorileylink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the commands a VirtualPump acknowledges bare and then takes
+// parameters for
+var virtualPumpParamCommands = map[CarelinkMessageType]bool{
+	CMTPowerOn:                true,
+	CMTChangeTime:             true,
+	CMTChangeTempBasal:        true,
+	CMTChangeTempBasalPercent: true,
+	CMTGetHistoryPage:         true,
+	CMTSuspendResume:          true,
+	CMTBolus:                  true,
+	CMTSetMaxBolus:            true,
+	CMTSetMaxBasalRate:        true,
+}
+
+// VirtualPump answers Carelink packets the way a real pump of its model
+// would, from state that tests can set up and inspect.  It sleeps until
+// woken with CMTPowerOn, like a real pump
+type VirtualPump struct {
+	sync.Mutex
+	ModelNumber int
+	PumpID      []byte
+	// ClockOffset is how far the pump clock is from the host's
+	ClockOffset time.Duration
+	Location    *time.Location
+	Battery     MMTBattery
+	// ReservoirUnits is in U
+	ReservoirUnits float64
+	Bolusing       bool
+	Suspended      bool
+	// MaxBolus is in U, MaxBasal in U/h
+	MaxBolus float64
+	MaxBasal float64
+	// TempBasal is the running temp basal, if any, and when it ends
+	TempBasal    *MMTTempBasal
+	TempBasalEnd time.Time
+	// HistoryPages holds the records of each history page, most recent
+	// first; they are padded and given their CRC16 when fetched
+	HistoryPages [][]byte
+	// SettingsCounter is bumped whenever the settings are changed
+	SettingsCounter byte
+
+	awakeUntil time.Time
+	// pending is a command acknowledged bare, waiting for its parameters
+	pending CarelinkMessageType
+	// frames are the rest of a multi-frame reply, sent one per ACK
+	frames    [][]byte
+	frameType CarelinkMessageType
+}
+
+// NewVirtualPump creates an idle pump with a full battery and reservoir
+// and a single empty history page
+func NewVirtualPump(model int, pumpID string) (*VirtualPump, error) {
+	id, err := ParsePumpID(pumpID)
+	if err != nil {
+		return nil, err
+	}
+	return &VirtualPump{
+		ModelNumber:    model,
+		PumpID:         id,
+		Location:       time.Local,
+		Battery:        MMTBattery{MMTBatteryNormal, 1.5},
+		ReservoirUnits: 150,
+		MaxBolus:       10,
+		MaxBasal:       2,
+		HistoryPages:   [][]byte{{}},
+	}, nil
+}
+
+func (vp *VirtualPump) pump() *MedtronicPump {
+	return &MedtronicPump{vp.ModelNumber}
+}
+
+// Now returns the pump clock
+func (vp *VirtualPump) Now() time.Time {
+	return time.Now().Add(vp.ClockOffset).In(vp.Location)
+}
+
+// Receive takes a decoded packet heard on the pump channel and returns the
+// decoded packet the pump answers with, or nil if it stays silent
+func (vp *VirtualPump) Receive(packet []byte) []byte {
+	vp.Lock()
+	defer vp.Unlock()
+	pumpID, msg, err := ParseCarelinkPacket(packet)
+	if err != nil || !bytes.Equal(pumpID, vp.PumpID) {
+		return nil
+	}
+	if time.Now().After(vp.awakeUntil) && msg.MessageType != CMTPowerOn {
+		return nil
+	}
+	reply := vp.handle(msg)
+	if reply == nil {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"received": msg.MessageType,
+		"sent":     reply.MessageType,
+	}).Debug("virtual pump")
+	return reply.Packet(vp.PumpID)
+}
+
+// reply builds a full reply body out of its contents
+func (vp *VirtualPump) reply(cmt CarelinkMessageType, contents ...byte) *CarelinkMessage {
+	return NewCarelinkParamMessage(cmt, contents)
+}
+
+func (vp *VirtualPump) ack() *CarelinkMessage {
+	return NewCarelinkShortMessage(CMTPumpAck)
+}
+
+// refuse answers with an error, the code alone in the body like an ACK's 0x00
+func (vp *VirtualPump) refuse(code MMTErrorCode) *CarelinkMessage {
+	return &CarelinkMessage{CMTErrorResponse, []byte{byte(code)}}
+}
+
+// handle answers one message, keeping track of parameterized commands and
+// multi-frame replies in progress
+func (vp *VirtualPump) handle(msg *CarelinkMessage) *CarelinkMessage {
+	if msg.MessageType == CMTPumpAck {
+		if len(vp.frames) == 0 {
+			return nil
+		}
+		frame := vp.frames[0]
+		vp.frames = vp.frames[1:]
+		return &CarelinkMessage{vp.frameType, frame}
+	}
+	vp.frames = nil
+	if len(msg.Data) < carelinkBodyLength {
+		vp.pending = 0
+		if virtualPumpParamCommands[msg.MessageType] {
+			if msg.MessageType == CMTPowerOn {
+				// the wakeup burst keeps the radio on long enough for
+				// the real wakeup to follow
+				vp.awakeUntil = time.Now().Add(time.Minute)
+			}
+			vp.pending = msg.MessageType
+			return vp.ack()
+		}
+		return vp.read(msg.MessageType)
+	}
+	if msg.MessageType != vp.pending {
+		return vp.refuse(MMTErrorCommandRefused)
+	}
+	vp.pending = 0
+	count := int(msg.Data[0])
+	if count > len(msg.Data)-1 {
+		return vp.refuse(MMTErrorCommandRefused)
+	}
+	return vp.set(msg.MessageType, msg.Data[1:1+count])
+}
+
+// read answers a parameterless command
+func (vp *VirtualPump) read(cmt CarelinkMessageType) *CarelinkMessage {
+	pump := vp.pump()
+	if !pump.Supports(cmt) {
+		return vp.refuse(MMTErrorCommandRefused)
+	}
+	switch cmt {
+	case CMTGetPumpModel:
+		model := strconv.Itoa(vp.ModelNumber)
+		return vp.reply(cmt, append([]byte{byte(len(model))}, model...)...)
+	case CMTReadTime:
+		return vp.reply(cmt, encodePumpTime(vp.Now())...)
+	case CMTGetBattery:
+		voltage := uint16(vp.Battery.Voltage * 100)
+		return vp.reply(cmt, byte(vp.Battery.Status), byte(voltage>>8), byte(voltage))
+	case CMTReadRemainingInsulin:
+		strokes := uint16(vp.ReservoirUnits * float64(pump.StrokesPerUnit()))
+		if pump.Modern() {
+			return vp.reply(cmt, 0, 0, byte(strokes>>8), byte(strokes))
+		}
+		return vp.reply(cmt, byte(strokes>>8), byte(strokes))
+	case CMTReadPumpStatus:
+		return vp.reply(cmt, 0x03, boolByte(vp.Bolusing), boolByte(vp.Suspended))
+	case CMTReadErrorStatus:
+		return vp.reply(cmt, byte(MMTAlarmNone))
+	case CMTReadTempBasal:
+		return vp.readTempBasal()
+	case CMTSettingsChangeCounter:
+		return vp.reply(cmt, 0, 0, vp.SettingsCounter)
+	case CMTReadSettings:
+		return vp.readSettings()
+	case CMTReadCurrentPageNumber:
+		number := make([]byte, 4)
+		binary.BigEndian.PutUint32(number, uint32(len(vp.HistoryPages)-1))
+		return vp.reply(cmt, number...)
+	default:
+		return vp.refuse(MMTErrorCommandRefused)
+	}
+}
+
+// readTempBasal reports the temp basal in the layout ReadTempBasal reads
+func (vp *VirtualPump) readTempBasal() *CarelinkMessage {
+	if vp.TempBasal == nil || !time.Now().Before(vp.TempBasalEnd) {
+		vp.TempBasal = nil
+		return vp.reply(CMTReadTempBasal, 0, 0, 0, 0, 0, 0)
+	}
+	strokes := uint16(vp.TempBasal.Rate * float64(vp.pump().BasalStrokesPerUnit()))
+	// the pump counts the minute in progress as remaining
+	minutes := uint16((time.Until(vp.TempBasalEnd) + time.Minute - 1) / time.Minute)
+	return vp.reply(CMTReadTempBasal,
+		byte(vp.TempBasal.Type), byte(vp.TempBasal.Percent),
+		byte(strokes>>8), byte(strokes),
+		byte(minutes>>8), byte(minutes))
+}
+
+// virtualPumpSettings is the contents of a CMTReadSettings reply captured
+// from a x23 pump: max bolus 15 U, max basal 3.5 U/h, standard profile,
+// 4 hour insulin action curve, low reservoir warning at 20 U
+var virtualPumpSettings = []byte{
+	0x00, 0x01, 0x00, 0x01, 0x01, 0x00, 0x96, 0x00, 0x8c, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x64, 0x01, 0x04, 0x00, 0x14, 0x00, 0x19, 0x01, 0x01, 0x01,
+}
+
+// readSettings reports the settings in the captured reply, with the max
+// bolus, max basal and temp basal type replaced by the pump's own.  x22
+// and older pumps have no bolus scroll step ahead of the max bolus
+func (vp *VirtualPump) readSettings() *CarelinkMessage {
+	contents := append([]byte(nil), virtualPumpSettings...)
+	maxBolus := contents[6:7]
+	maxBasal := contents[7:9]
+	if !vp.pump().Modern() {
+		maxBolus = contents[5:6]
+		maxBasal = contents[6:8]
+		contents[8] = 0x00
+	}
+	maxBolus[0] = byte(vp.MaxBolus * mmtMaxBolusMultiplier)
+	binary.BigEndian.PutUint16(maxBasal, uint16(vp.MaxBasal*mmtMaxBasalMultiplier))
+	contents[14] = byte(MMTTempBasalAbsolute)
+	if vp.TempBasal != nil {
+		contents[14] = byte(vp.TempBasal.Type)
+		contents[15] = byte(vp.TempBasal.Percent)
+	}
+	return vp.reply(CMTReadSettings, contents...)
+}
+
+// set carries out a command with its parameters
+func (vp *VirtualPump) set(cmt CarelinkMessageType, params []byte) *CarelinkMessage {
+	pump := vp.pump()
+	switch {
+	case cmt == CMTPowerOn && len(params) >= 2:
+		vp.awakeUntil = time.Now().Add(time.Duration(params[1]) * time.Minute)
+	case cmt == CMTChangeTime:
+		t, err := decodePumpTime(params, vp.Location)
+		if err != nil {
+			return vp.refuse(MMTErrorCommandRefused)
+		}
+		vp.ClockOffset = time.Until(t)
+	case cmt == CMTChangeTempBasal && len(params) >= 3:
+		if vp.Suspended {
+			return vp.refuse(MMTErrorCommandRefused)
+		}
+		rate := float64(int(params[0])<<8|int(params[1])) / float64(pump.BasalStrokesPerUnit())
+		if rate > vp.MaxBasal {
+			return vp.refuse(MMTErrorMaxSettingExceeded)
+		}
+		vp.setTempBasal(&MMTTempBasal{Type: MMTTempBasalAbsolute, Rate: rate}, params[2])
+	case cmt == CMTChangeTempBasalPercent && len(params) >= 2:
+		if vp.Suspended {
+			return vp.refuse(MMTErrorCommandRefused)
+		}
+		vp.setTempBasal(&MMTTempBasal{Type: MMTTempBasalPercent, Percent: int(params[0])}, params[1])
+	case cmt == CMTSuspendResume && len(params) >= 1:
+		vp.Suspended = params[0] == 0x01
+	case cmt == CMTBolus && len(params) >= 1:
+		strokes := int(params[0])
+		if len(params) >= 2 && pump.StrokesPerUnit() >= 40 {
+			strokes = int(params[0])<<8 | int(params[1])
+		}
+		units := float64(strokes) / float64(pump.StrokesPerUnit())
+		if vp.Suspended {
+			return vp.refuse(MMTErrorCommandRefused)
+		} else if vp.Bolusing {
+			return vp.refuse(MMTErrorBolusInProgress)
+		} else if units > vp.MaxBolus {
+			return vp.refuse(MMTErrorMaxSettingExceeded)
+		}
+		vp.ReservoirUnits -= units
+		vp.recordBolus(strokes)
+		if pump.HasBolusErrorQuirk() {
+			// x15 pumps answer a bolus they deliver with an error
+			return vp.refuse(MMTErrorBolusInProgress)
+		}
+	case cmt == CMTSetMaxBolus && len(params) >= 1:
+		vp.MaxBolus = float64(params[0]) / mmtMaxBolusMultiplier
+		vp.SettingsCounter++
+	case cmt == CMTSetMaxBasalRate && len(params) >= 2:
+		vp.MaxBasal = float64(int(params[0])<<8|int(params[1])) / mmtMaxBasalMultiplier
+		vp.SettingsCounter++
+	case cmt == CMTGetHistoryPage && len(params) >= 1:
+		return vp.historyPage(int(params[0]))
+	default:
+		return vp.refuse(MMTErrorCommandRefused)
+	}
+	return vp.ack()
+}
+
+// recordBolus adds a normal bolus record to the current history page
+func (vp *VirtualPump) recordBolus(strokes int) {
+	record := []byte{byte(MMTRecordBolusNormal), byte(strokes), byte(strokes), 0x00}
+	if vp.pump().NewRecordStyle() {
+		record = []byte{byte(MMTRecordBolusNormal),
+			byte(strokes >> 8), byte(strokes), byte(strokes >> 8), byte(strokes),
+			0x00, 0x00, 0x00}
+	}
+	record = append(record, encodeHistoryTimestamp(vp.Now())...)
+	vp.HistoryPages[0] = append(vp.HistoryPages[0], record...)
+}
+
+// encodeHistoryTimestamp is the inverse of decodeHistoryTimestamp
+func encodeHistoryTimestamp(t time.Time) []byte {
+	month := byte(t.Month())
+	return []byte{
+		byte(t.Second()) | month&0x0c<<4,
+		byte(t.Minute()) | month&0x03<<6,
+		byte(t.Hour()),
+		byte(t.Day()),
+		byte(t.Year() - 2000),
+	}
+}
+
+// setTempBasal starts (or with no segments, cancels) a temp basal
+func (vp *VirtualPump) setTempBasal(tempBasal *MMTTempBasal, segments byte) {
+	if segments == 0 {
+		vp.TempBasal = nil
+		return
+	}
+	tempBasal.Duration = time.Duration(segments) * mmtTempBasalSegment
+	vp.TempBasal = tempBasal
+	vp.TempBasalEnd = time.Now().Add(tempBasal.Duration)
+}
+
+// historyPage starts sending a history page as 64-byte frames, returning
+// the first; the rest go out as they are ACKed
+func (vp *VirtualPump) historyPage(number int) *CarelinkMessage {
+	if number >= len(vp.HistoryPages) {
+		return vp.refuse(MMTErrorPageDoesNotExist)
+	}
+	page := make([]byte, mmtHistoryPageLength)
+	copy(page[:mmtHistoryPageLength-2], vp.HistoryPages[number])
+	binary.BigEndian.PutUint16(page[mmtHistoryPageLength-2:], CRC16(page[:mmtHistoryPageLength-2]))
+	var frames [][]byte
+	for n := 0; n*64 < len(page); n++ {
+		frame := make([]byte, carelinkBodyLength)
+		frame[0] = byte(n + 1)
+		copy(frame[1:], page[n*64:])
+		frames = append(frames, frame)
+	}
+	frames[len(frames)-1][0] |= 0x80
+	vp.frameType = CMTGetHistoryPage
+	vp.frames = frames[1:]
+	return &CarelinkMessage{CMTGetHistoryPage, frames[0]}
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 0x01
+	}
+	return 0x00
+}
+
+// VirtualRileyLink is a PacketRadio whose pump channel reaches a
+// VirtualPump directly, without any radio in between
+type VirtualRileyLink struct {
+	Pump *VirtualPump
+	// RSSI is reported for every packet received, in dBm
+	RSSI int
+}
+
+// NewVirtualRileyLink creates a RileyLink in range of the given pump
+func NewVirtualRileyLink(vp *VirtualPump) *VirtualRileyLink {
+	return &VirtualRileyLink{Pump: vp, RSSI: -60}
+}
+
+// encodeCCRSSI is the inverse of decodeCCRSSI
+func encodeCCRSSI(rssi int) byte {
+	return byte(int8((rssi + 73) * 2))
+}
+
+// GetPacket waits out the timeout; a virtual pump never speaks unasked
+func (vrl *VirtualRileyLink) GetPacket(rlpc RileyLinkPacketChannel, timeout time.Duration) (*RLCCResponse, error) {
+	time.Sleep(timeout)
+	return &RLCCResponse{Result: RLRRecvTimeout}, nil
+}
+
+// SendPacket delivers a packet to the pump, discarding any answer
+func (vrl *VirtualRileyLink) SendPacket(rlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, preamble time.Duration) error {
+	if rlpc == RLPCPump {
+		vrl.Pump.Receive(Decode4b6b(packet))
+	}
+	return nil
+}
+
+// SendAndListen delivers a packet to the pump and returns its answer the
+// way subg_rfspy would
+func (vrl *VirtualRileyLink) SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error) {
+	if sendrlpc != RLPCPump || listenrlpc != RLPCPump {
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	reply := vrl.Pump.Receive(Decode4b6b(packet))
+	if reply == nil {
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	payload := []byte{encodeCCRSSI(vrl.RSSI), 0x01}
+	payload = append(payload, Encode4b6b(reply)...)
+	return &RLCCResponse{Result: RLRSuccess, Payload: append(payload, 0x00)}, nil
+}
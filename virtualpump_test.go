@@ -0,0 +1,288 @@
+// virtualpump_test.go contains tests of pump sessions against the virtual
+// pump: waking it, multi-frame history pages, temp basals and boluses
+
+package gorileylink
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyRileyLink is a VirtualRileyLink that loses a number of exchanges,
+// as if the pump were out of range
+type flakyRileyLink struct {
+	*VirtualRileyLink
+	lose int32
+}
+
+func (frl *flakyRileyLink) SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error) {
+	if atomic.AddInt32(&frl.lose, -1) >= 0 {
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	atomic.StoreInt32(&frl.lose, 0)
+	return frl.VirtualRileyLink.SendAndListen(sendrlpc, packet, repeat, delay, listenrlpc, timeout, retries, preamble)
+}
+
+// newVirtualSession sets up a session with a virtual pump
+func newVirtualSession(t *testing.T, model int) (*MMTPumpSession, *VirtualPump, *flakyRileyLink) {
+	t.Helper()
+	vp, err := NewVirtualPump(model, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	radio := &flakyRileyLink{VirtualRileyLink: NewVirtualRileyLink(vp)}
+	mps, err := NewMMTPumpSession(radio, nil, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mps, vp, radio
+}
+
+// tamperingRileyLink is a VirtualRileyLink that passes every exchange,
+// decoded, through tamper, which may change or drop the pump's reply
+type tamperingRileyLink struct {
+	*VirtualRileyLink
+	tamper func(sent []byte, reply []byte, retries byte) []byte
+}
+
+func (trl *tamperingRileyLink) SendAndListen(sendrlpc RileyLinkPacketChannel, packet []byte, repeat byte, delay time.Duration, listenrlpc RileyLinkPacketChannel, timeout time.Duration, retries byte, preamble time.Duration) (*RLCCResponse, error) {
+	response, err := trl.VirtualRileyLink.SendAndListen(sendrlpc, packet, repeat, delay, listenrlpc, timeout, retries, preamble)
+	if err != nil || response.Result != RLRSuccess {
+		return response, err
+	}
+	reply := trl.tamper(Decode4b6b(packet), Decode4b6b(response.Payload[2:]), retries)
+	if reply == nil {
+		return &RLCCResponse{Result: RLRRecvTimeout}, nil
+	}
+	payload := append(response.Payload[:2:2], Encode4b6b(reply)...)
+	return &RLCCResponse{Result: RLRSuccess, Payload: append(payload, 0x00)}, nil
+}
+
+// newTamperedSession sets up a session with a virtual pump through a
+// tamperingRileyLink
+func newTamperedSession(t *testing.T, model int, tamper func([]byte, []byte, byte) []byte) (*MMTPumpSession, *VirtualPump) {
+	t.Helper()
+	vp, err := NewVirtualPump(model, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	radio := &tamperingRileyLink{NewVirtualRileyLink(vp), tamper}
+	mps, err := NewMMTPumpSession(radio, nil, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mps, vp
+}
+
+// alwaysConfirm approves every bolus
+func alwaysConfirm(units float64, pump *MedtronicPump) bool {
+	return true
+}
+
+func TestVirtualPumpWakeup(t *testing.T) {
+	mps, vp, _ := newVirtualSession(t, 554)
+	getModel := NewCarelinkShortMessage(CMTGetPumpModel).Packet(vp.PumpID)
+	if vp.Receive(getModel) != nil {
+		t.Fatal("asleep pump answered")
+	}
+	model, err := mps.ReadPumpModel()
+	if err != nil {
+		t.Fatal(err)
+	} else if model != 554 {
+		t.Errorf("model %d", model)
+	}
+	if vp.Receive(getModel) == nil {
+		t.Error("woken pump didn't answer")
+	}
+	other, _ := ParsePumpID("654321")
+	if vp.Receive(NewCarelinkShortMessage(CMTGetPumpModel).Packet(other)) != nil {
+		t.Error("pump answered another pump's packet")
+	}
+}
+
+func TestVirtualPumpSettingsReply(t *testing.T) {
+	vp, err := NewVirtualPump(554, "594040")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp.MaxBolus, vp.MaxBasal = 15, 3.5
+	if got := hex.EncodeToString(vp.readSettings().Packet(vp.PumpID)); got != settingsCaptureModern {
+		t.Errorf("settings reply %s, captured %s", got, settingsCaptureModern)
+	}
+
+	for _, model := range []int{522, 554} {
+		mps, vp, _ := newVirtualSession(t, model)
+		vp.MaxBolus, vp.MaxBasal = 12.5, 1.25
+		settings, err := mps.ReadSettings()
+		if err != nil {
+			t.Fatal(err)
+		} else if settings.MaxBolus != 12.5 || settings.MaxBasal != 1.25 {
+			t.Errorf("%d: max bolus %v U, max basal %v U/h", model, settings.MaxBolus, settings.MaxBasal)
+		}
+	}
+}
+
+func TestVirtualPumpHistoryPage(t *testing.T) {
+	mps, vp, _ := newVirtualSession(t, 554)
+	vp.recordBolus(60)
+	page, err := mps.GetHistoryPage(0)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(page.Data) != mmtHistoryPageLength || page.Attempts != 1 {
+		t.Fatalf("%d bytes in %d attempts", len(page.Data), page.Attempts)
+	} else if !bytes.Equal(page.Data[:len(vp.HistoryPages[0])], vp.HistoryPages[0]) {
+		t.Errorf("page starts %x", page.Data[:len(vp.HistoryPages[0])])
+	}
+	events := page.Events(mps.Location)
+	if bolus, ok := events[0].(*MMTBolusEvent); !ok || bolus.Programmed != 1.5 || bolus.Amount != 1.5 {
+		t.Errorf("first event %+v", events[0])
+	}
+
+	_, err = mps.GetHistoryPage(1)
+	if perr, ok := err.(*MMTPumpError); !ok || perr.Code != MMTErrorPageDoesNotExist {
+		t.Errorf("missing page: %v", err)
+	}
+}
+
+// corruptHistory spoils a byte of the next n history pages sent, keeping
+// the packet CRC good so that only the page CRC can catch it
+func corruptHistory(n int) func([]byte, []byte, byte) []byte {
+	return func(sent []byte, reply []byte, retries byte) []byte {
+		// the first frame of each page is numbered 1
+		if n > 0 && len(reply) > 20 && CarelinkMessageType(reply[4]) == CMTGetHistoryPage && reply[5] == 0x01 {
+			n--
+			reply[20] ^= 0xff
+			reply[len(reply)-1] = CRC8(reply[:len(reply)-1])
+		}
+		return reply
+	}
+}
+
+func TestVirtualPumpRefusal(t *testing.T) {
+	vp, err := NewVirtualPump(554, "594040")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(vp.refuse(MMTErrorBolusInProgress).Packet(vp.PumpID)); got != "a7594040150c78" {
+		t.Errorf("refusal %s", got)
+	}
+}
+
+func TestVirtualPumpHistoryPageCRC(t *testing.T) {
+	mps, _ := newTamperedSession(t, 554, corruptHistory(1))
+	page, err := mps.GetHistoryPage(0)
+	if err != nil {
+		t.Fatal(err)
+	} else if page.Attempts != 2 {
+		t.Errorf("corrupted page fetched in %d attempts", page.Attempts)
+	}
+
+	mps, _ = newTamperedSession(t, 554, corruptHistory(mmtHistoryPageAttempts))
+	if _, err = mps.GetHistoryPage(0); err == nil {
+		t.Error("page corrupted every time fetched")
+	}
+}
+
+func TestVirtualPumpTempBasal(t *testing.T) {
+	mps, vp, _ := newVirtualSession(t, 554)
+	running, err := mps.SetTempBasal(MMTTempBasal{Type: MMTTempBasalAbsolute, Rate: 1.525, Duration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	} else if running.Rate != 1.525 || running.Duration != time.Hour {
+		t.Errorf("running %+v", running)
+	}
+
+	running, err = mps.SetTempBasal(MMTTempBasal{Type: MMTTempBasalPercent, Percent: 150, Duration: 30 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	} else if running.Type != MMTTempBasalPercent || running.Percent != 150 {
+		t.Errorf("running %+v", running)
+	}
+	settings, err := mps.ReadSettings()
+	if err != nil {
+		t.Fatal(err)
+	} else if settings.TempBasalType != MMTTempBasalPercent || settings.TempBasalPercent != 150 {
+		t.Errorf("settings show a %v temp basal of %d%%", settings.TempBasalType, settings.TempBasalPercent)
+	}
+
+	if _, err = mps.SetTempBasal(MMTTempBasal{Type: MMTTempBasalAbsolute, Rate: vp.MaxBasal + 0.5, Duration: time.Hour}); err == nil {
+		t.Error("temp basal over the max basal set")
+	}
+
+	// cancelling needs no validation, even with settings the temp basal
+	// couldn't have been set under
+	vp.MaxBasal = 0
+	err = mps.CancelTempBasal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err = mps.ReadTempBasal()
+	if err != nil {
+		t.Fatal(err)
+	} else if running.Duration != 0 {
+		t.Errorf("still running %+v", running)
+	}
+}
+
+func TestVirtualPumpTempBasalLegacy(t *testing.T) {
+	mps, _, _ := newVirtualSession(t, 522)
+	running, err := mps.SetTempBasal(MMTTempBasal{Type: MMTTempBasalAbsolute, Rate: 0.05, Duration: 30 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	} else if running.Rate != 0.05 {
+		t.Errorf("running %+v", running)
+	}
+	_, err = mps.SetTempBasal(MMTTempBasal{Type: MMTTempBasalPercent, Percent: 50, Duration: 30 * time.Minute})
+	if err == nil {
+		t.Error("percent temp basal set on a 522")
+	}
+}
+
+func TestVirtualPumpBolus(t *testing.T) {
+	// the 515 answers every bolus with an error, and its delivery is
+	// confirmed from history instead
+	for _, model := range []int{515, 522, 554} {
+		mps, vp, _ := newVirtualSession(t, model)
+		err := mps.Bolus(1.5, 5, alwaysConfirm)
+		if err != nil {
+			t.Errorf("%d: %v", model, err)
+		}
+		if math.Abs(vp.ReservoirUnits-148.5) > 1e-6 {
+			t.Errorf("%d: %v U left", model, vp.ReservoirUnits)
+		}
+
+		err = mps.Bolus(1, 5, func(units float64, pump *MedtronicPump) bool { return false })
+		if err == nil || math.Abs(vp.ReservoirUnits-148.5) > 1e-6 {
+			t.Errorf("%d: unconfirmed bolus delivered (%v)", model, err)
+		}
+		if err = mps.Bolus(vp.MaxBolus+1, 20, alwaysConfirm); err == nil {
+			t.Errorf("%d: bolus over the pump's max bolus delivered", model)
+		}
+	}
+}
+
+func TestVirtualPumpBolusReplyLost(t *testing.T) {
+	// the pump delivers, but its answer to the bolus never arrives
+	sends := 0
+	mps, vp := newTamperedSession(t, 554, func(sent []byte, reply []byte, retries byte) []byte {
+		_, msg, err := ParseCarelinkPacket(sent)
+		if err != nil || msg.MessageType != CMTBolus || len(msg.Data) == 0 || msg.Data[0] == 0 {
+			return reply
+		}
+		sends++
+		if retries != 0 {
+			t.Errorf("bolus sent with %d retries", retries)
+		}
+		return nil
+	})
+	err := mps.Bolus(2, 5, alwaysConfirm)
+	if err != nil {
+		t.Error(err)
+	} else if sends != 1 || math.Abs(vp.ReservoirUnits-148) > 1e-6 {
+		t.Errorf("bolus sent %d times, %v U left", sends, vp.ReservoirUnits)
+	}
+}